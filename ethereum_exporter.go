@@ -8,10 +8,14 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/melonproject/ethereum-exporter/monitor"
 )
 
+// gracefulTimeout is how long to wait for the monitor to stop
+const gracefulTimeout = 5 * time.Second
+
 func main() {
 	if err := run(os.Args); err != nil {
 		fmt.Printf("[ERR]: %v", err)
@@ -37,6 +41,7 @@ func readConfigFile(path string) (*monitor.Config, error) {
 func readConfig(args []string) (*monitor.Config, error) {
 
 	var fileConfigPath string
+	var disableConsul bool
 
 	config := monitor.DefaultConfig()
 
@@ -52,11 +57,44 @@ func readConfig(args []string) (*monitor.Config, error) {
 	flag.StringVar(&cliConfig.Endpoint, "endpoint", "", "")
 	flag.StringVar(&cliConfig.NodeName, "nodename", "", "")
 	flag.StringVar(&cliConfig.BindAddr, "bind", "", "")
+	flag.StringVar(&cliConfig.MetricsPath, "metrics-path", "", "")
+	flag.StringVar(&cliConfig.SyncedPath, "synced-path", "", "")
 	flag.IntVar(&cliConfig.BindPort, "port", 0, "")
-	flag.IntVar(&cliConfig.SyncThreshold, "threshold", 5, "")
+	flag.IntVar(&cliConfig.SyncThreshold, "threshold", 0, "")
+	flag.IntVar(&cliConfig.SyncThresholdSeconds, "threshold-seconds", 0, "")
+	flag.Int64Var(&cliConfig.ExpectedChainID, "chain-id", 0, "")
+	flag.Int64Var(&cliConfig.MinBlockNumber, "min-block", 0, "")
+	flag.IntVar(&cliConfig.GatherConcurrency, "gather-concurrency", 0, "")
+	flag.IntVar(&cliConfig.WatchdogIntervals, "watchdog-intervals", 0, "")
+	flag.DurationVar(&cliConfig.ExpectedBlockTime, "blocktime", 0, "")
+	flag.DurationVar(&cliConfig.ReferenceInterval, "reference-interval", 0, "")
+	flag.DurationVar(&cliConfig.StartupGracePeriod, "grace-period", 0, "")
+	flag.DurationVar(&cliConfig.StartupWait, "startup-wait", 0, "")
+	flag.DurationVar(&cliConfig.InmemInterval, "inmem-interval", 0, "")
+	flag.DurationVar(&cliConfig.InmemRetain, "inmem-retain", 0, "")
+	flag.DurationVar(&cliConfig.DNSCacheTTL, "dns-cache-ttl", 0, "")
+	flag.DurationVar(&cliConfig.PeerWindow, "peer-window", 0, "")
+	flag.BoolVar(&cliConfig.EtherscanV2, "etherscan-v2", false, "")
+	flag.BoolVar(&cliConfig.StrictChain, "strict-chain", false, "")
+	flag.BoolVar(&cliConfig.IntervalJitter, "jitter", false, "")
+	flag.StringVar(&cliConfig.EtherscanAPIKey, "etherscan-apikey", "", "")
+	flag.StringVar(&cliConfig.WatchdogAction, "watchdog-action", "", "")
+	flag.StringVar(&cliConfig.PrimaryBlockTag, "block-tag", "", "")
+	flag.StringVar(&cliConfig.RemoteWriteURL, "remote-write-url", "", "")
+	flag.StringVar(&cliConfig.WebhookURL, "webhook-url", "", "")
+	flag.StringVar(&cliConfig.InfluxURL, "influx-url", "", "")
+	flag.StringVar(&cliConfig.InfluxDB, "influx-db", "", "")
+	flag.StringVar(&cliConfig.GraphiteAddr, "graphite-addr", "", "")
+
+	flag.BoolVar(&disableConsul, "disable-consul", false, "")
 
 	flag.Parse()
 
+	if disableConsul {
+		enabled := false
+		cliConfig.ConsulConfig.Enabled = &enabled
+	}
+
 	if fileConfigPath != "" {
 		var err error
 
@@ -74,14 +112,15 @@ func readConfig(args []string) (*monitor.Config, error) {
 
 func run(args []string) error {
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	config, err := readConfig(args)
 	if err != nil {
 		return fmt.Errorf("Failed to read config: %v", err)
 	}
 
-	prettyConfig, err := json.MarshalIndent(config, "", "\t")
+	prettyConfig, err := json.MarshalIndent(config.Redacted(), "", "\t")
 	if err != nil {
 		return fmt.Errorf("Failed to prettify config: %v", err)
 	}
@@ -101,9 +140,20 @@ func run(args []string) error {
 		return fmt.Errorf("Failed to start the monitor: %v", err)
 	}
 
-	for range c {
-		ctx.Done()
-		break
+	var fatalErr error
+	select {
+	case sig := <-c:
+		monitor.SetShutdownReason(fmt.Sprintf("signal %v", sig))
+	case fatalErr = <-monitor.Fatal():
+	}
+	cancel()
+
+	if err := monitor.Wait(gracefulTimeout); err != nil {
+		return fmt.Errorf("Failed to stop the monitor: %v", err)
+	}
+
+	if fatalErr != nil {
+		return fmt.Errorf("Monitor stopped: %v", fatalErr)
 	}
 
 	return nil
@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+var validMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// aliasSink renames the metrics before passing them to the sink. The
+// aliases are keyed by the dotted name without the service prefix (i.e.
+// "blockNumber") and replace the whole key, prefix included.
+type aliasSink struct {
+	sink    metrics.MetricSink
+	service string
+	aliases map[string]string
+}
+
+func newAliasSink(sink metrics.MetricSink, service string, aliases map[string]string) metrics.MetricSink {
+	if len(aliases) == 0 {
+		return sink
+	}
+	return &aliasSink{sink, service, aliases}
+}
+
+func (a *aliasSink) rename(key []string) []string {
+	if len(key) == 0 || key[0] != a.service {
+		return key
+	}
+	if alias, ok := a.aliases[strings.Join(key[1:], ".")]; ok {
+		return []string{alias}
+	}
+	return key
+}
+
+func (a *aliasSink) SetGauge(key []string, val float32) {
+	a.sink.SetGauge(a.rename(key), val)
+}
+
+func (a *aliasSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	a.sink.SetGaugeWithLabels(a.rename(key), val, labels)
+}
+
+func (a *aliasSink) EmitKey(key []string, val float32) {
+	a.sink.EmitKey(a.rename(key), val)
+}
+
+func (a *aliasSink) IncrCounter(key []string, val float32) {
+	a.sink.IncrCounter(a.rename(key), val)
+}
+
+func (a *aliasSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	a.sink.IncrCounterWithLabels(a.rename(key), val, labels)
+}
+
+func (a *aliasSink) AddSample(key []string, val float32) {
+	a.sink.AddSample(a.rename(key), val)
+}
+
+func (a *aliasSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	a.sink.AddSampleWithLabels(a.rename(key), val, labels)
+}
+
+// validateMetricAliases checks the aliased metrics exist and the aliases
+// are unique valid prometheus names
+func validateMetricAliases(config *Config) error {
+	known := map[string]bool{}
+	for _, desc := range MetricNames(config) {
+		known[desc.Name] = true
+	}
+
+	seen := map[string]string{}
+	for name, alias := range config.MetricAliases {
+		if !known[name] {
+			return fmt.Errorf("Unknown metric '%s' in the metric aliases", name)
+		}
+		if !validMetricName.MatchString(alias) {
+			return fmt.Errorf("Alias '%s' of %s is not a valid metric name", alias, name)
+		}
+		if other, ok := seen[alias]; ok {
+			return fmt.Errorf("Alias '%s' used for both %s and %s", alias, other, name)
+		}
+		seen[alias] = name
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BeaconClient talks to the consensus client Beacon API
+type BeaconClient struct {
+	addr   string
+	client *http.Client
+}
+
+func NewBeaconClient(addr string) *BeaconClient {
+	return &BeaconClient{
+		addr:   strings.TrimRight(addr, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type BeaconHead struct {
+	Slot int64
+
+	// Execution block included in the head. Nil before the merge
+	ExecutionBlock *big.Int
+}
+
+// Head returns the head slot from the header and the execution block
+// from the blinded block, which has the payload header in place of the
+// transactions. The full block is never downloaded.
+func (b *BeaconClient) Head() (*BeaconHead, error) {
+	var header struct {
+		Data struct {
+			Root   string `json:"root"`
+			Header struct {
+				Message struct {
+					Slot string `json:"slot"`
+				} `json:"message"`
+			} `json:"header"`
+		} `json:"data"`
+	}
+
+	if err := b.get("/eth/v1/beacon/headers/head", &header); err != nil {
+		return nil, fmt.Errorf("failed to get beacon head header: %v", err)
+	}
+
+	slot, err := strconv.ParseInt(header.Data.Header.Message.Slot, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse slot: %s", header.Data.Header.Message.Slot)
+	}
+
+	head := &BeaconHead{
+		Slot: slot,
+	}
+
+	// by root, the head may have moved since the header
+	var block struct {
+		Data struct {
+			Message struct {
+				Body struct {
+					ExecutionPayloadHeader *struct {
+						BlockNumber string `json:"block_number"`
+					} `json:"execution_payload_header"`
+				} `json:"body"`
+			} `json:"message"`
+		} `json:"data"`
+	}
+
+	if err := b.get("/eth/v1/beacon/blinded_blocks/"+header.Data.Root, &block); err != nil {
+		return nil, fmt.Errorf("failed to get beacon head block: %v", err)
+	}
+
+	if payload := block.Data.Message.Body.ExecutionPayloadHeader; payload != nil {
+		blockNumber, ok := big.NewInt(0).SetString(payload.BlockNumber, 10)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse execution block number: %s", payload.BlockNumber)
+		}
+		head.ExecutionBlock = blockNumber
+	}
+
+	return head, nil
+}
+
+func (b *BeaconClient) get(path string, out interface{}) error {
+	resp, err := b.client.Get(b.addr + path)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("status code %d different from 200: %s", resp.StatusCode, string(data))
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to unmarshall response: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBeaconHead(t *testing.T) {
+	root := "0xabc"
+
+	beacon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/eth/v1/beacon/headers/head":
+			fmt.Fprintf(w, `{"data":{"root":"%s","header":{"message":{"slot":"7000000"}}}}`, root)
+		case "/eth/v1/beacon/blinded_blocks/" + root:
+			fmt.Fprint(w, `{"data":{"message":{"slot":"7000000","body":{"execution_payload_header":{"block_number":"18000000"}}}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer beacon.Close()
+
+	head, err := NewBeaconClient(beacon.URL + "/").Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Slot != 7000000 {
+		t.Fatalf("expected slot 7000000, found %d", head.Slot)
+	}
+	if head.ExecutionBlock == nil || head.ExecutionBlock.Int64() != 18000000 {
+		t.Fatalf("expected execution block 18000000, found %v", head.ExecutionBlock)
+	}
+}
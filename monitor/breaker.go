@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = fmt.Errorf("circuit open, skipping rpc call")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops the rpc calls for a cool down period after
+// a number of consecutive failures. Once the cool down expires a single
+// call is let through to probe if the node has recovered.
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+
+	lock     sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// newCircuitBreaker returns nil (always closed) if threshold is zero
+func newCircuitBreaker(threshold int, coolDown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+	}
+}
+
+// Allow returns whether a call can be done
+func (c *circuitBreaker) Allow() bool {
+	if c == nil {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < c.coolDown {
+			return false
+		}
+		c.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// the probe is still in flight
+		return false
+	}
+
+	return true
+}
+
+// Record updates the breaker with the result of a call
+func (c *circuitBreaker) Record(err error) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err == nil {
+		c.state = circuitClosed
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.state == circuitHalfOpen || c.failures >= c.threshold {
+		c.state = circuitOpen
+		c.openedAt = time.Now()
+	}
+}
+
+// IsOpen returns true unless the calls are going through normally
+func (c *circuitBreaker) IsOpen() bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.state != circuitClosed
+}
@@ -0,0 +1,30 @@
+package monitor
+
+import (
+	"sync"
+)
+
+// changeFilter remembers the last value of every series to tell if it
+// changed. Used by the push sinks to not resend the unchanged gauges.
+type changeFilter struct {
+	lock sync.Mutex
+	last map[string]float64
+}
+
+func newChangeFilter() *changeFilter {
+	return &changeFilter{
+		last: map[string]float64{},
+	}
+}
+
+// changed records val as the value of key and returns false if it is the
+// same as the previous one
+func (c *changeFilter) changed(key string, val float64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	last, ok := c.last[key]
+	c.last[key] = val
+
+	return !ok || last != val
+}
@@ -1,19 +1,44 @@
 package monitor
 
 import (
+	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
 type ConsulConfig struct {
+	// Register the service in consul. Defaults to true
+	Enabled *bool `json:"enabled"`
+
 	Address     string   `json:"address"`
 	ServiceName string   `json:"service_name"`
 	Tags        []string `json:"tags"`
+
+	// Register even if the service id is already registered by
+	// another node
+	AllowOverwrite bool `json:"allow_overwrite"`
+
+	// Path of the health check. Defaults to the synced path
+	CheckPath string `json:"check_path"`
+
+	// Do not verify the certificate of the http api in the health check
+	// (i.e. self signed)
+	TLSSkipVerify bool `json:"tls_skip_verify"`
+
+	// Datacenters to register the service in besides the one of the
+	// agent. They are catalog registrations without a health check, no
+	// agent of those datacenters runs it
+	Datacenters []string `json:"datacenters"`
 }
 
 func DefaultConsulConfig() *ConsulConfig {
+	enabled := true
+
 	return &ConsulConfig{
+		Enabled:     &enabled,
 		Address:     "http://127.0.0.1:8500",
 		ServiceName: "pool",
 		Tags:        []string{"pool", "parity"},
@@ -21,6 +46,9 @@ func DefaultConsulConfig() *ConsulConfig {
 }
 
 func (c *ConsulConfig) Merge(c1 *ConsulConfig) {
+	if c1.Enabled != nil {
+		c.Enabled = c1.Enabled
+	}
 	if c1.Address != "" {
 		c.Address = c1.Address
 	}
@@ -30,6 +58,45 @@ func (c *ConsulConfig) Merge(c1 *ConsulConfig) {
 	if len(c1.Tags) != 0 {
 		c.Tags = c1.Tags
 	}
+	if c1.AllowOverwrite {
+		c.AllowOverwrite = c1.AllowOverwrite
+	}
+	if c1.CheckPath != "" {
+		c.CheckPath = c1.CheckPath
+	}
+	if c1.TLSSkipVerify {
+		c.TLSSkipVerify = c1.TLSSkipVerify
+	}
+	if len(c1.Datacenters) != 0 {
+		c.Datacenters = c1.Datacenters
+	}
+}
+
+// EventFilter is a contract event whose occurrences are counted
+type EventFilter struct {
+	Address string `json:"address"`
+	Topic0  string `json:"topic0"`
+	Metric  string `json:"metric"`
+}
+
+// StorageTarget is a contract storage slot exported as a gauge
+type StorageTarget struct {
+	Address string `json:"address"`
+	Slot    string `json:"slot"`
+	Metric  string `json:"metric"`
+}
+
+// ContractCall is a view function whose result is exported as a gauge
+type ContractCall struct {
+	Address string `json:"address"`
+
+	// Hex encoded selector and arguments of the call
+	CallData string `json:"call_data"`
+
+	// Type of the first returned word: uint, int or bool
+	ResultDecoder string `json:"result_decoder"`
+
+	Metric string `json:"metric"`
 }
 
 type Config struct {
@@ -37,26 +104,311 @@ type Config struct {
 	BindAddr    string `json:"bind"`
 	BindPort    int    `json:"port"`
 	Endpoint    string `json:"endpoint"`
+	MetricsPath string `json:"metrics_path"`
+	SyncedPath  string `json:"synced_path"`
 	NodeName    string `json:"nodename"`
 	RPCInterval time.Duration
 
+	// Delay the first cycle by a random fraction of RPCInterval
+	IntervalJitter bool `json:"interval_jitter"`
+
+	// Time Start waits for the node to answer before starting the gather
+	// loop. Zero starts right away
+	StartupWait time.Duration
+
+	// Interval between reference height lookups. External explorers are
+	// rate limited so it is usually longer than RPCInterval
+	ReferenceInterval time.Duration
+
+	// Intervals without a gather loop iteration after which the watchdog
+	// trips, at least 2. Zero disables the watchdog
+	WatchdogIntervals int `json:"watchdog_intervals"`
+
+	// What the watchdog does when it trips: "exit" stops the exporter
+	// with an error so it is restarted, "restart" starts a new gather loop
+	WatchdogAction string `json:"watchdog_action"`
+
 	// Consul config
 	ConsulConfig *ConsulConfig `json:"consul"`
 
-	// Sync threashold
+	// Sync threashold in blocks. Zero derives it from
+	// SyncThresholdSeconds, or uses 5 blocks if not set either
 	SyncThreshold int
+
+	// Seconds behind the reference the node is still synced. Divided
+	// by the block time of the chain on every lookup
+	SyncThresholdSeconds int `json:"sync_threshold_seconds"`
+
+	// Time after startup during which /synced reports success unless
+	// the node is unreachable
+	StartupGracePeriod time.Duration
+
+	// Weights of the inputs of the /score endpoint
+	ScoreWeights *ScoreWeights `json:"score_weights"`
+
+	// Peer counts for the /check endpoint. Under MinPeers is critical
+	// and under WarnPeers is a warning
+	MinPeers  int `json:"min_peers"`
+	WarnPeers int `json:"warn_peers"`
+
+	// Max peers of the node. Read from parity_netPeers if zero
+	MaxPeers int `json:"max_peers"`
+
+	// Window of the distinct peers count and the peer ids kept for it.
+	// The least recently seen peers are dropped past MaxSeenPeers
+	PeerWindow   time.Duration
+	MaxSeenPeers int `json:"max_seen_peers"`
+
+	// Cycles with an unchanged block number after which the node is not
+	// synced. Opt-in, zero (the default) disables it
+	StalledCyclesThreshold int `json:"stalled_cycles_threshold"`
+
+	// Cycles syncing with an unchanged current block after which the
+	// sync is stalled. Opt-in, zero (the default) disables it
+	SyncStalledCycles int `json:"sync_stalled_cycles"`
+
+	// Block number the node must reach to be synced, so a wiped node is
+	// not ready while the reference lags too. Zero disables it
+	MinBlockNumber int64 `json:"min_block_number"`
+
+	// Expected time between blocks. If not set it depends on the chain
+	ExpectedBlockTime time.Duration
+
+	// Use the etherscan v2 api, which takes the chain id instead of
+	// having a subdomain per network
+	EtherscanV2     bool   `json:"etherscan_v2"`
+	EtherscanAPIKey string `json:"etherscan_api_key"`
+
+	// Stop the exporter if the chain of the node is unknown instead of
+	// retrying the connection
+	StrictChain bool `json:"strict_chain"`
+
+	// Chain id the node must report with eth_chainId. Zero disables the
+	// check
+	ExpectedChainID int64 `json:"expected_chain_id"`
+
+	// Explorers queried in order for the reference block height. The
+	// first one that answers is used. Defaults to etherscan if empty
+	ReferenceExplorers []ExplorerConfig `json:"reference_explorers"`
+
+	// Proxy of the rpc and explorer requests (i.e. http://proxy:3128 or
+	// socks5://proxy:1080). Defaults to the HTTP_PROXY and HTTPS_PROXY
+	// environment variables
+	HTTPProxy string `json:"http_proxy"`
+
+	// How long the last resolved ips of the endpoints are used if the
+	// dns lookup fails. Zero disables it
+	DNSCacheTTL time.Duration
+
+	// Max size of a rpc response body, to not run out of memory with a
+	// broken endpoint. Zero means unlimited
+	MaxResponseBytes int64 `json:"max_response_bytes"`
+
+	// Certificate of the http api. Served over http if empty
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+
+	// Client certificate and ca used to connect to the rpc endpoints
+	RPCClientCertFile string `json:"rpc_client_cert_file"`
+	RPCClientKeyFile  string `json:"rpc_client_key_file"`
+	RPCCAFile         string `json:"rpc_ca_file"`
+
+	// Endpoints compared against each other by block number (i.e. the
+	// backends of a load balancer)
+	PeerEndpoints []string `json:"peer_endpoints"`
+
+	// Beacon API of the consensus client paired with the node. Optional
+	ConsensusEndpoint string `json:"consensus_endpoint"`
+
+	// Send the calls of every cycle as a single batched request
+	BatchRPC bool `json:"batch_rpc"`
+
+	// Calls of every cycle done in parallel if not batched. Zero or one
+	// does them one by one
+	GatherConcurrency int `json:"gather_concurrency"`
+
+	// Timeout of the rpc call done by the /livez endpoint
+	LivenessTimeout time.Duration
+
+	// Bearer token of the /selftest endpoint. The endpoint is disabled
+	// if empty
+	SelfTestToken string `json:"self_test_token"`
+
+	// Consecutive rpc failures that open the circuit. Opt-in, zero (the
+	// default) disables it
+	RPCBreakerThreshold int `json:"rpc_breaker_threshold"`
+
+	// Time the rpc calls are skipped once the circuit opens
+	RPCBreakerCoolDown time.Duration
+
+	// Fetch the full transactions every N blocks to export aggregates
+	// of them. Zero disables it
+	FullBlockSampleRate int `json:"full_block_sample_rate"`
+
+	// Block tag of the blockNumber metric and the block metrics: latest,
+	// safe or finalized. The sync state always follows the latest block
+	PrimaryBlockTag string `json:"primary_block_tag"`
+
+	// Client specific method that returns the blocks waiting to be
+	// imported. Probed on connect and only called while syncing.
+	// Disabled if empty
+	SyncQueueMethod string `json:"sync_queue_method"`
+
+	// Client specific method that returns the size of the database in
+	// bytes. Probed on connect and called every DBSizeInterval since it
+	// is slow on some clients. Disabled if empty
+	DBSizeMethod   string `json:"db_size_method"`
+	DBSizeInterval time.Duration
+
+	// Groups of calls skipped in every cycle (i.e. "txpool",
+	// "blocksbehind"), to reduce the calls to rate limited providers
+	DisabledMetrics []string `json:"disabled_metrics"`
+
+	// Methods probed on connect. The optional calls of the probed methods
+	// are skipped if the node does not implement them
+	ProbeMethods []string `json:"probe_methods"`
+
+	// Miners whose blocks are counted, the rest are counted as other.
+	// Disabled if empty
+	KnownMiners []string `json:"known_miners"`
+
+	// Number of heads the blocks per miner are counted over
+	MinerWindow int `json:"miner_window"`
+
+	// Number of blocks the average fullness is computed over
+	FullnessWindow int `json:"fullness_window"`
+
+	// Export the gap between the pending and the latest block. Needs
+	// support for the pending block tag
+	PendingGap bool `json:"pending_gap"`
+
+	// Gas price caps in gwei. Exported as over or under the current price
+	GasPriceCaps []int `json:"gas_price_caps"`
+
+	// Contract storage slots to export
+	WatchStorage []StorageTarget `json:"watch_storage"`
+
+	// Contract view functions to call on the latest block and export
+	WatchCalls []ContractCall `json:"watch_calls"`
+
+	// Contract events to count
+	WatchEvents []EventFilter `json:"watch_events"`
+
+	// Max blocks scanned for events per cycle. The scan catches up over
+	// several cycles after a downtime
+	MaxLogsRange int `json:"max_logs_range"`
+
+	// Addresses whose pending transactions are tracked
+	WatchAddresses []string `json:"watch_addresses"`
+
+	// Export the pending transactions of every watched address. Read
+	// from a dump of the whole txpool, or from the nonce gap if the node
+	// does not support it
+	AccountPendingTxs bool `json:"account_pending_txs"`
+
+	// Account that sends a zero value transfer to itself every
+	// CanaryInterval to check the node accepts transactions. It spends
+	// gas and must be unlocked in the node. Disabled if empty
+	CanaryAddress  string `json:"canary_address"`
+	CanaryInterval time.Duration
+	CanaryTimeout  time.Duration
+
+	// Max pending transactions of the txpool. Read from the node if
+	// zero, only parity exposes it
+	TxPoolMaxPending int64 `json:"txpool_max_pending"`
+
+	// Interval between txpool checks. The txpool dump is expensive
+	TxPoolInterval time.Duration
+
+	// Aggregation interval and retention of the in-memory metrics
+	InmemInterval time.Duration
+	InmemRetain   time.Duration
+
+	// Never gzip the metrics endpoint, even if the client accepts it
+	DisableMetricsCompression bool `json:"disable_metrics_compression"`
+
+	// Add the hostname as a label to every metric. Defaults to true
+	EnableHostnameLabel *bool `json:"enable_hostname_label"`
+
+	// Prometheus remote write endpoint the metrics are pushed to on
+	// every cycle. Disabled if empty
+	RemoteWriteURL string `json:"remote_write_url"`
+
+	// Url the connection and sync state changes are posted to as json.
+	// Disabled if empty
+	WebhookURL string `json:"webhook_url"`
+
+	// Renames the metrics (i.e. "blockNumber": "eth_head_block"). The
+	// alias replaces the whole name, service prefix included
+	MetricAliases map[string]string `json:"metric_aliases"`
+
+	// Only push the gauges whose value changed since the previous push.
+	// The prometheus endpoint is not affected
+	EmitOnChangeOnly bool `json:"emit_on_change_only"`
+
+	// InfluxDB sink. Disabled if InfluxURL is empty
+	InfluxURL      string `json:"influx_url"`
+	InfluxDB       string `json:"influx_db"`
+	InfluxUsername string `json:"influx_username"`
+	InfluxPassword string `json:"influx_password"`
+
+	// Graphite sink, the carbon plaintext listener (i.e. graphite:2003).
+	// Disabled if GraphiteAddr is empty
+	GraphiteAddr   string `json:"graphite_addr"`
+	GraphitePrefix string `json:"graphite_prefix"`
 }
 
 func DefaultConfig() *Config {
+	enableHostnameLabel := true
+
 	c := &Config{
-		LogOutput:     os.Stderr,
-		BindAddr:      "127.0.0.1",
-		BindPort:      4546,
-		NodeName:      "parity",
-		Endpoint:      "http://127.0.0.1:8545",
-		ConsulConfig:  DefaultConsulConfig(),
-		RPCInterval:   time.Duration(5) * time.Second,
-		SyncThreshold: 5,
+		LogOutput:    os.Stderr,
+		BindAddr:     "127.0.0.1",
+		BindPort:     4546,
+		NodeName:     "parity",
+		Endpoint:     "http://127.0.0.1:8545",
+		MetricsPath:  "/metrics",
+		SyncedPath:   "/synced",
+		ConsulConfig: DefaultConsulConfig(),
+		RPCInterval:  time.Duration(5) * time.Second,
+
+		ReferenceInterval: time.Duration(15) * time.Second,
+
+		WatchdogAction: watchdogExit,
+
+		ScoreWeights: DefaultScoreWeights(),
+
+		MinPeers: 1,
+
+		PeerWindow:   time.Duration(1) * time.Hour,
+		MaxSeenPeers: 10000,
+
+		PrimaryBlockTag: "latest",
+
+		MaxLogsRange: 1000,
+
+		FullnessWindow: 20,
+		MinerWindow:    100,
+
+		ProbeMethods: []string{"parity_netPeers", "parity_pendingTransactions", "admin_nodeInfo", "parity_transactionsLimit", "txpool_status", "eth_feeHistory", "admin_peers"},
+
+		TxPoolInterval: time.Duration(1) * time.Minute,
+
+		DBSizeInterval: time.Duration(1) * time.Hour,
+
+		CanaryInterval: time.Duration(10) * time.Minute,
+		CanaryTimeout:  time.Duration(5) * time.Minute,
+
+		LivenessTimeout: time.Duration(2) * time.Second,
+
+		MaxResponseBytes: 8 * 1024 * 1024,
+
+		RPCBreakerCoolDown: time.Duration(30) * time.Second,
+
+		InmemInterval: time.Duration(10) * time.Second,
+		InmemRetain:   time.Duration(1) * time.Minute,
+
+		EnableHostnameLabel: &enableHostnameLabel,
 	}
 
 	if hostname, err := os.Hostname(); err == nil {
@@ -79,11 +431,393 @@ func (c *Config) Merge(c1 *Config) {
 	if c1.Endpoint != "" {
 		c.Endpoint = c1.Endpoint
 	}
+	if c1.MetricsPath != "" {
+		c.MetricsPath = c1.MetricsPath
+	}
+	if c1.SyncedPath != "" {
+		c.SyncedPath = c1.SyncedPath
+	}
+	if c1.IntervalJitter {
+		c.IntervalJitter = c1.IntervalJitter
+	}
+	if c1.StartupWait != 0 {
+		c.StartupWait = c1.StartupWait
+	}
+	if c1.ReferenceInterval != 0 {
+		c.ReferenceInterval = c1.ReferenceInterval
+	}
+	if c1.WatchdogIntervals != 0 {
+		c.WatchdogIntervals = c1.WatchdogIntervals
+	}
+	if c1.WatchdogAction != "" {
+		c.WatchdogAction = c1.WatchdogAction
+	}
 	if c1.SyncThreshold != 0 {
 		c.SyncThreshold = c1.SyncThreshold
 	}
+	if c1.SyncThresholdSeconds != 0 {
+		c.SyncThresholdSeconds = c1.SyncThresholdSeconds
+	}
+	if c1.StartupGracePeriod != 0 {
+		c.StartupGracePeriod = c1.StartupGracePeriod
+	}
+	if c1.ScoreWeights != nil {
+		c.ScoreWeights = c1.ScoreWeights
+	}
+	if c1.MinPeers != 0 {
+		c.MinPeers = c1.MinPeers
+	}
+	if c1.WarnPeers != 0 {
+		c.WarnPeers = c1.WarnPeers
+	}
+	if c1.MaxPeers != 0 {
+		c.MaxPeers = c1.MaxPeers
+	}
+	if c1.PeerWindow != 0 {
+		c.PeerWindow = c1.PeerWindow
+	}
+	if c1.MaxSeenPeers != 0 {
+		c.MaxSeenPeers = c1.MaxSeenPeers
+	}
+	if c1.StalledCyclesThreshold != 0 {
+		c.StalledCyclesThreshold = c1.StalledCyclesThreshold
+	}
+	if c1.SyncStalledCycles != 0 {
+		c.SyncStalledCycles = c1.SyncStalledCycles
+	}
+	if c1.MinBlockNumber != 0 {
+		c.MinBlockNumber = c1.MinBlockNumber
+	}
+	if c1.ExpectedBlockTime != 0 {
+		c.ExpectedBlockTime = c1.ExpectedBlockTime
+	}
+	if c1.EtherscanV2 {
+		c.EtherscanV2 = c1.EtherscanV2
+	}
+	if c1.EtherscanAPIKey != "" {
+		c.EtherscanAPIKey = c1.EtherscanAPIKey
+	}
+	if c1.ExpectedChainID != 0 {
+		c.ExpectedChainID = c1.ExpectedChainID
+	}
+	if c1.StrictChain {
+		c.StrictChain = c1.StrictChain
+	}
+	if len(c1.ReferenceExplorers) != 0 {
+		c.ReferenceExplorers = c1.ReferenceExplorers
+	}
+	if c1.HTTPProxy != "" {
+		c.HTTPProxy = c1.HTTPProxy
+	}
+	if c1.DNSCacheTTL != 0 {
+		c.DNSCacheTTL = c1.DNSCacheTTL
+	}
+	if c1.MaxResponseBytes != 0 {
+		c.MaxResponseBytes = c1.MaxResponseBytes
+	}
+	if c1.TLSCertFile != "" {
+		c.TLSCertFile = c1.TLSCertFile
+	}
+	if c1.TLSKeyFile != "" {
+		c.TLSKeyFile = c1.TLSKeyFile
+	}
+	if c1.RPCClientCertFile != "" {
+		c.RPCClientCertFile = c1.RPCClientCertFile
+	}
+	if c1.RPCClientKeyFile != "" {
+		c.RPCClientKeyFile = c1.RPCClientKeyFile
+	}
+	if c1.RPCCAFile != "" {
+		c.RPCCAFile = c1.RPCCAFile
+	}
+	if len(c1.PeerEndpoints) != 0 {
+		c.PeerEndpoints = c1.PeerEndpoints
+	}
+	if c1.ConsensusEndpoint != "" {
+		c.ConsensusEndpoint = c1.ConsensusEndpoint
+	}
+	if c1.BatchRPC {
+		c.BatchRPC = c1.BatchRPC
+	}
+	if c1.GatherConcurrency != 0 {
+		c.GatherConcurrency = c1.GatherConcurrency
+	}
+	if c1.LivenessTimeout != 0 {
+		c.LivenessTimeout = c1.LivenessTimeout
+	}
+	if c1.SelfTestToken != "" {
+		c.SelfTestToken = c1.SelfTestToken
+	}
+	if c1.RPCBreakerThreshold != 0 {
+		c.RPCBreakerThreshold = c1.RPCBreakerThreshold
+	}
+	if c1.RPCBreakerCoolDown != 0 {
+		c.RPCBreakerCoolDown = c1.RPCBreakerCoolDown
+	}
+	if c1.FullBlockSampleRate != 0 {
+		c.FullBlockSampleRate = c1.FullBlockSampleRate
+	}
+	if c1.PrimaryBlockTag != "" {
+		c.PrimaryBlockTag = c1.PrimaryBlockTag
+	}
+	if c1.SyncQueueMethod != "" {
+		c.SyncQueueMethod = c1.SyncQueueMethod
+	}
+	if c1.DBSizeMethod != "" {
+		c.DBSizeMethod = c1.DBSizeMethod
+	}
+	if c1.DBSizeInterval != 0 {
+		c.DBSizeInterval = c1.DBSizeInterval
+	}
+	if len(c1.DisabledMetrics) != 0 {
+		c.DisabledMetrics = c1.DisabledMetrics
+	}
+	if len(c1.ProbeMethods) != 0 {
+		c.ProbeMethods = c1.ProbeMethods
+	}
+	if len(c1.KnownMiners) != 0 {
+		c.KnownMiners = c1.KnownMiners
+	}
+	if c1.MinerWindow != 0 {
+		c.MinerWindow = c1.MinerWindow
+	}
+	if c1.FullnessWindow != 0 {
+		c.FullnessWindow = c1.FullnessWindow
+	}
+	if c1.PendingGap {
+		c.PendingGap = c1.PendingGap
+	}
+	if len(c1.GasPriceCaps) != 0 {
+		c.GasPriceCaps = c1.GasPriceCaps
+	}
+	if len(c1.WatchStorage) != 0 {
+		c.WatchStorage = c1.WatchStorage
+	}
+	if len(c1.WatchCalls) != 0 {
+		c.WatchCalls = c1.WatchCalls
+	}
+	if len(c1.WatchEvents) != 0 {
+		c.WatchEvents = c1.WatchEvents
+	}
+	if c1.MaxLogsRange != 0 {
+		c.MaxLogsRange = c1.MaxLogsRange
+	}
+	if len(c1.WatchAddresses) != 0 {
+		c.WatchAddresses = c1.WatchAddresses
+	}
+	if c1.AccountPendingTxs {
+		c.AccountPendingTxs = c1.AccountPendingTxs
+	}
+	if c1.CanaryAddress != "" {
+		c.CanaryAddress = c1.CanaryAddress
+	}
+	if c1.CanaryInterval != 0 {
+		c.CanaryInterval = c1.CanaryInterval
+	}
+	if c1.CanaryTimeout != 0 {
+		c.CanaryTimeout = c1.CanaryTimeout
+	}
+	if c1.TxPoolMaxPending != 0 {
+		c.TxPoolMaxPending = c1.TxPoolMaxPending
+	}
+	if c1.TxPoolInterval != 0 {
+		c.TxPoolInterval = c1.TxPoolInterval
+	}
+	if c1.InmemInterval != 0 {
+		c.InmemInterval = c1.InmemInterval
+	}
+	if c1.InmemRetain != 0 {
+		c.InmemRetain = c1.InmemRetain
+	}
+	if c1.DisableMetricsCompression {
+		c.DisableMetricsCompression = c1.DisableMetricsCompression
+	}
+	if c1.EnableHostnameLabel != nil {
+		c.EnableHostnameLabel = c1.EnableHostnameLabel
+	}
+	if c1.RemoteWriteURL != "" {
+		c.RemoteWriteURL = c1.RemoteWriteURL
+	}
+	if c1.WebhookURL != "" {
+		c.WebhookURL = c1.WebhookURL
+	}
+	if len(c1.MetricAliases) != 0 {
+		c.MetricAliases = c1.MetricAliases
+	}
+	if c1.EmitOnChangeOnly {
+		c.EmitOnChangeOnly = c1.EmitOnChangeOnly
+	}
+	if c1.InfluxURL != "" {
+		c.InfluxURL = c1.InfluxURL
+	}
+	if c1.InfluxDB != "" {
+		c.InfluxDB = c1.InfluxDB
+	}
+	if c1.InfluxUsername != "" {
+		c.InfluxUsername = c1.InfluxUsername
+	}
+	if c1.InfluxPassword != "" {
+		c.InfluxPassword = c1.InfluxPassword
+	}
+	if c1.GraphiteAddr != "" {
+		c.GraphiteAddr = c1.GraphiteAddr
+	}
+	if c1.GraphitePrefix != "" {
+		c.GraphitePrefix = c1.GraphitePrefix
+	}
 
 	if c1.ConsulConfig != nil {
+		if c.ConsulConfig == nil {
+			c.ConsulConfig = &ConsulConfig{}
+		}
 		c.ConsulConfig.Merge(c1.ConsulConfig)
 	}
 }
+
+// normalizeEndpoint defaults the endpoint scheme to http and rejects
+// the schemes the rpc client can not talk to.
+func normalizeEndpoint(endpoint string) (string, error) {
+	if endpoint == "" {
+		return "", fmt.Errorf("endpoint is empty")
+	}
+
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("endpoint '%s' is not a valid url", redactURL(endpoint))
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("endpoint '%s' has unsupported scheme '%s'. Only 'http' and 'https' are supported", redactURL(endpoint), u.Scheme)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("endpoint '%s' has no host", redactURL(endpoint))
+	}
+
+	return u.String(), nil
+}
+
+const redacted = "<redacted>"
+
+// IsEnabled returns false if the service should not be registered
+func (c *ConsulConfig) IsEnabled() bool {
+	if c == nil {
+		return false
+	}
+	if c.Enabled != nil && !*c.Enabled {
+		return false
+	}
+	return c.Address != ""
+}
+
+// Validate checks the fields required to register the service and
+// defaults the service name to the node name if empty
+func (c *ConsulConfig) Validate(nodeName string) error {
+	if c == nil {
+		return fmt.Errorf("consul config is empty")
+	}
+	if c.Address == "" {
+		return fmt.Errorf("consul address is empty")
+	}
+	if nodeName == "" {
+		return fmt.Errorf("consul registration requires a node name to use as service id")
+	}
+	for _, datacenter := range c.Datacenters {
+		if datacenter == "" {
+			return fmt.Errorf("consul datacenter names can not be empty")
+		}
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = nodeName
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with the sensitive fields hidden
+func (c *Config) Redacted() *Config {
+	c1 := *c
+
+	if c1.ConsulConfig != nil {
+		consulConfig := *c1.ConsulConfig
+		c1.ConsulConfig = &consulConfig
+	}
+
+	c1.Endpoint = redactURL(c1.Endpoint)
+	c1.ConsensusEndpoint = redactURL(c1.ConsensusEndpoint)
+
+	c1.PeerEndpoints = make([]string, len(c.PeerEndpoints))
+	for indx, endpoint := range c.PeerEndpoints {
+		c1.PeerEndpoints[indx] = redactURL(endpoint)
+	}
+	c1.InfluxURL = redactURL(c1.InfluxURL)
+	c1.HTTPProxy = redactURL(c1.HTTPProxy)
+	c1.RemoteWriteURL = redactURL(c1.RemoteWriteURL)
+
+	if c1.InfluxPassword != "" {
+		c1.InfluxPassword = redacted
+	}
+	if c1.EtherscanAPIKey != "" {
+		c1.EtherscanAPIKey = redacted
+	}
+	if c1.SelfTestToken != "" {
+		c1.SelfTestToken = redacted
+	}
+	// chat webhooks carry the token in the path
+	if c1.WebhookURL != "" {
+		c1.WebhookURL = redacted
+	}
+
+	c1.ReferenceExplorers = make([]ExplorerConfig, len(c.ReferenceExplorers))
+	for indx, explorer := range c.ReferenceExplorers {
+		explorer.URL = redactURL(explorer.URL)
+		if explorer.APIKey != "" {
+			explorer.APIKey = redacted
+		}
+		c1.ReferenceExplorers[indx] = explorer
+	}
+
+	return &c1
+}
+
+// redactURL hides the parts of the url that may carry a secret: the
+// password of the userinfo, the path (i.e. /v3/<key> of infura) and the
+// query values (i.e. apikey=). The scheme and the host are kept.
+func redactURL(raw string) string {
+	if raw == "" {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+
+	if u.Opaque != "" {
+		u.Opaque = redacted
+	}
+	if u.Path != "" && u.Path != "/" {
+		u.Path = "/" + redacted
+		u.RawPath = ""
+	}
+
+	query := u.Query()
+	for key := range query {
+		query.Set(key, redacted)
+	}
+	u.RawQuery = query.Encode()
+	u.Fragment = ""
+
+	return u.String()
+}
@@ -0,0 +1,103 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// dnsCache resolves the host of the connections and keeps the last
+// resolved ips. If a lookup fails the cached ips are used until the ttl
+// expires, so a short dns outage does not stop the monitoring.
+type dnsCache struct {
+	ttl    time.Duration
+	logger *log.Logger
+	dialer *net.Dialer
+
+	// base labels of the failures. Nil adds only the host
+	labels func() []metrics.Label
+
+	lock    sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips        []net.IPAddr
+	resolvedAt time.Time
+}
+
+func newDNSCache(ttl time.Duration, logger *log.Logger, labels func() []metrics.Label) *dnsCache {
+	return &dnsCache{
+		ttl:     ttl,
+		logger:  logger,
+		labels:  labels,
+		dialer:  &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		entries: map[string]dnsEntry{},
+	}
+}
+
+// DialContext is the dial function of the http transport
+func (d *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing to resolve
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialErr error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = err
+	}
+
+	return nil, dialErr
+}
+
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err == nil && len(ips) != 0 {
+		d.lock.Lock()
+		d.entries[host] = dnsEntry{ips, time.Now()}
+		d.lock.Unlock()
+		return ips, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no addresses found for %s", host)
+	}
+
+	var labels []metrics.Label
+	if d.labels != nil {
+		labels = d.labels()
+	}
+	labels = append(labels, metrics.Label{Name: "endpoint_host", Value: host})
+	metrics.IncrCounterWithLabels([]string{"rpc", "dnsFailures"}, 1, labels)
+
+	d.lock.Lock()
+	entry, ok := d.entries[host]
+	d.lock.Unlock()
+
+	if ok && time.Since(entry.resolvedAt) < d.ttl {
+		d.logger.Printf("[WARN] DNS resolution of %s failed, using the ips resolved %s ago: %v", host, time.Since(entry.resolvedAt).Round(time.Second), err)
+		return entry.ips, nil
+	}
+
+	d.logger.Printf("[WARN] DNS resolution of %s failed: %v", host, err)
+	return nil, err
+}
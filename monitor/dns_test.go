@@ -0,0 +1,42 @@
+package monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+func TestDNSCacheFailure(t *testing.T) {
+	var labelled bool
+	labels := func() []metrics.Label {
+		labelled = true
+		return []metrics.Label{{Name: "node", Value: "test"}}
+	}
+
+	d := newDNSCache(time.Minute, log.New(ioutil.Discard, "", 0), labels)
+
+	cached := []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}
+	d.entries["node.invalid"] = dnsEntry{cached, time.Now()}
+
+	ips, err := d.lookup(context.Background(), "node.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].IP.Equal(cached[0].IP) {
+		t.Fatalf("expected the cached ips, found %v", ips)
+	}
+	if !labelled {
+		t.Fatal("expected the failure with the base labels")
+	}
+
+	// expired
+	d.entries["node.invalid"] = dnsEntry{cached, time.Now().Add(-2 * time.Minute)}
+	if _, err := d.lookup(context.Background(), "node.invalid"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
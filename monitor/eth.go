@@ -2,14 +2,24 @@ package monitor
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"math/big"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/mapstructure"
 )
@@ -23,15 +33,55 @@ func args(in ...interface{}) []interface{} {
 }
 
 type Etherscan struct {
-	addr string
+	addr   string
+	client *http.Client
 }
 
-func NewEtherscan(addr string) *Etherscan {
-	return &Etherscan{addr}
+func NewEtherscan(addr string, config *Config) (*Etherscan, error) {
+	transport, err := newExplorerTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEtherscan(addr, transport), nil
+}
+
+// newEtherscan returns an explorer that sends the requests through the
+// transport, shared by the explorers created on every reconnect
+func newEtherscan(addr string, transport http.RoundTripper) *Etherscan {
+	return &Etherscan{
+		addr:   addr,
+		client: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}
+}
+
+// newExplorerTransport returns the transport of the reference explorers.
+// Only the proxy applies, the tls settings are for our own node.
+func newExplorerTransport(config *Config) (*http.Transport, error) {
+	proxy, err := newProxy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:           proxy,
+		IdleConnTimeout: rpcIdleConnTimeout,
+	}
+
+	return transport, nil
 }
 
 func (e *Etherscan) BlockNumber() (*big.Int, error) {
-	resp, err := http.Get(e.addr)
+	return e.BlockNumberContext(context.Background())
+}
+
+func (e *Etherscan) BlockNumberContext(ctx context.Context) (*big.Int, error) {
+	req, err := http.NewRequest("GET", e.addr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -52,11 +102,179 @@ func (e *Etherscan) BlockNumber() (*big.Int, error) {
 }
 
 type EthClient struct {
-	addr string
+	lastID int64
+
+	addr    string
+	client  *http.Client
+	breaker *circuitBreaker
+
+	// max size of a response body. Zero means unlimited
+	maxResponseBytes int64
+
+	// set once the endpoint answers a batched request with a single
+	// response
+	batchUnsupported bool
+
+	// bounds the calls without an explicit context. Set by the gather
+	// loop so the watchdog can cancel a hung call
+	ctxLock sync.Mutex
+	ctx     context.Context
+
+	// base labels of the monitor, added to the response metrics. The
+	// response sizes are not exported without them
+	labelsLock sync.Mutex
+	labels     []metrics.Label
+
+	// calls per method since the last CallCounts
+	callsLock sync.Mutex
+	calls     map[string]int
+}
+
+// rpcIdleConnTimeout closes the keep-alive connections to the node left
+// idle, i.e. when the endpoint moved to other ips
+const rpcIdleConnTimeout = 90 * time.Second
+
+func NewEthClient(addr string, config *Config) (*EthClient, error) {
+	logOutput := config.LogOutput
+	if logOutput == nil {
+		logOutput = ioutil.Discard
+	}
+	dns := newDNSCache(config.DNSCacheTTL, log.New(logOutput, "", log.LstdFlags), nil)
+
+	transport, err := newRPCTransport(config, dns)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEthClient(addr, config, transport), nil
+}
+
+// newEthClient returns a client that sends the requests through the
+// transport. The monitor reuses the transport of the endpoint across
+// reconnects so the connections of the replaced clients are not leaked.
+func newEthClient(addr string, config *Config, transport http.RoundTripper) *EthClient {
+	return &EthClient{
+		addr:    addr,
+		client:  &http.Client{Transport: transport},
+		breaker: newCircuitBreaker(config.RPCBreakerThreshold, config.RPCBreakerCoolDown),
+
+		maxResponseBytes: config.MaxResponseBytes,
+	}
+}
+
+// newRPCTransport returns the transport of the rpc endpoint with the tls
+// settings and the proxy of the config. The connections are resolved by
+// the dns cache.
+func newRPCTransport(config *Config, dns *dnsCache) (*http.Transport, error) {
+	tlsConfig, err := newRPCTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := newProxy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		DialContext:     dns.DialContext,
+		IdleConnTimeout: rpcIdleConnTimeout,
+	}
+
+	return transport, nil
+}
+
+// newProxy returns the proxy of the outbound requests. HTTPProxy takes
+// precedence over the HTTP_PROXY and HTTPS_PROXY variables.
+func newProxy(config *Config) (func(*http.Request) (*url.URL, error), error) {
+	if config.HTTPProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(config.HTTPProxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid http proxy '%s'", redactURL(config.HTTPProxy))
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// newRPCTLSConfig returns the tls config with the client certificate and
+// the ca of the rpc endpoint. Nil if none of them is set.
+func newRPCTLSConfig(config *Config) (*tls.Config, error) {
+	if config.RPCClientCertFile == "" && config.RPCClientKeyFile == "" && config.RPCCAFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if config.RPCClientCertFile != "" || config.RPCClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.RPCClientCertFile, config.RPCClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rpc client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.RPCCAFile != "" {
+		ca, err := ioutil.ReadFile(config.RPCCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rpc ca file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in rpc ca file %s", config.RPCCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
 }
 
-func NewEthClient(addr string) *EthClient {
-	return &EthClient{addr}
+// nextID returns a new request id
+func (e *EthClient) nextID() int {
+	return int(atomic.AddInt64(&e.lastID, 1))
+}
+
+// idMismatch counts a response with another id than the request. Like
+// the response sizes, it is not exported without the base labels
+func (e *EthClient) idMismatch(method string) {
+	if labels := e.metricLabels(metrics.Label{Name: "method", Value: method}); labels != nil {
+		metrics.IncrCounterWithLabels([]string{"rpc", "idMismatches"}, 1, labels)
+	}
+}
+
+func (e *EthClient) countCall(method string) {
+	e.callsLock.Lock()
+	defer e.callsLock.Unlock()
+
+	if e.calls == nil {
+		e.calls = map[string]int{}
+	}
+	e.calls[method]++
+}
+
+// CallCounts returns the calls made per method since the previous
+// invocation and resets them. Methods called before are kept with zero
+// so they do not go stale.
+func (e *EthClient) CallCounts() map[string]int {
+	e.callsLock.Lock()
+	defer e.callsLock.Unlock()
+
+	counts := map[string]int{}
+	for method, n := range e.calls {
+		counts[method] = n
+		e.calls[method] = 0
+	}
+	return counts
+}
+
+// CircuitOpen returns true if the rpc calls are being skipped
+func (e *EthClient) CircuitOpen() bool {
+	return e.breaker.IsOpen()
 }
 
 type RPCRequest struct {
@@ -66,61 +284,256 @@ type RPCRequest struct {
 	Params  interface{} `json:"params"`
 }
 
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (r *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", r.Code, r.Message)
+}
+
 type RPCResult struct {
 	JsonRPC string          `json:"jsonrpc"`
 	ID      int             `json:"id"`
 	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error"`
+}
+
+// SetContext sets the context of the calls made without one. A cancelled
+// context fails the pending and the following calls.
+func (e *EthClient) SetContext(ctx context.Context) {
+	e.ctxLock.Lock()
+	defer e.ctxLock.Unlock()
+
+	e.ctx = ctx
+}
+
+func (e *EthClient) context() context.Context {
+	e.ctxLock.Lock()
+	defer e.ctxLock.Unlock()
+
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// SetLabels sets the base labels of the response metrics
+func (e *EthClient) SetLabels(labels []metrics.Label) {
+	e.labelsLock.Lock()
+	defer e.labelsLock.Unlock()
+
+	e.labels = labels
+}
+
+// metricLabels returns the base labels with the extra ones, nil if the
+// base labels are not set
+func (e *EthClient) metricLabels(extra ...metrics.Label) []metrics.Label {
+	e.labelsLock.Lock()
+	defer e.labelsLock.Unlock()
+
+	if e.labels == nil {
+		return nil
+	}
+
+	labels := make([]metrics.Label, 0, len(e.labels)+len(extra))
+	labels = append(labels, e.labels...)
+	return append(labels, extra...)
 }
 
 func (e *EthClient) rpcCall(method string, in, out interface{}) error {
+	return e.rpcCallContext(e.context(), method, in, out)
+}
+
+func (e *EthClient) rpcCallContext(ctx context.Context, method string, in, out interface{}) error {
 	if in == nil {
 		in = []interface{}{}
 	}
 
 	reqBody := RPCRequest{
-		Id:      1,
+		Id:      e.nextID(),
 		Jsonrpc: "2.0",
 		Method:  method,
 		Params:  in,
 	}
 
-	client := &http.Client{}
+	e.countCall(method)
+	data, err := e.post(ctx, method, reqBody)
+	if err != nil {
+		return err
+	}
 
-	reqData, err := json.Marshal(reqBody)
+	result, err := parseResult(data)
 	if err != nil {
 		return err
 	}
 
-	body := bytes.NewBuffer(reqData)
+	// a different id means a proxy mixed up the responses
+	if result.ID != reqBody.Id {
+		e.idMismatch(method)
+		return fmt.Errorf("response id %d does not match request id %d", result.ID, reqBody.Id)
+	}
 
-	req, err := http.NewRequest("POST", e.addr, body)
+	err = json.Unmarshal(result.Result, out)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to unmarshall result: %v", err)
+	}
+
+	return err
+}
+
+// post sends the payload to the node and returns the response body.
+// method is only used to label the metrics.
+func (e *EthClient) post(ctx context.Context, method string, payload interface{}) ([]byte, error) {
+	reqData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", e.addr, bytes.NewBuffer(reqData))
+	if err != nil {
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
+	// once allowed, every return path records the result so a half
+	// open breaker is not left waiting for its probe
+	if !e.breaker.Allow() {
+		return nil, errCircuitOpen
+	}
+
+	resp, err := e.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return err
+		e.breaker.Record(err)
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	data, err := ensureOk(resp)
-	if err != nil {
-		return err
+	var reader io.Reader = resp.Body
+	if e.maxResponseBytes > 0 {
+		// one more byte to tell a body of exactly the limit from a larger one
+		reader = io.LimitReader(resp.Body, e.maxResponseBytes+1)
+	}
+
+	body := &countingReader{r: reader}
+	resp.Body = ioutil.NopCloser(body)
+
+	data, err := readOk(resp)
+	e.breaker.Record(err)
+
+	// the node answered, so it does not count as a failure for the breaker
+	if err == nil && e.maxResponseBytes > 0 && body.n > e.maxResponseBytes {
+		if labels := e.metricLabels(metrics.Label{Name: "method", Value: method}); labels != nil {
+			metrics.IncrCounterWithLabels([]string{"rpc", "responseTooLarge"}, 1, labels)
+		}
+		err = fmt.Errorf("response of %s larger than %d bytes", method, e.maxResponseBytes)
 	}
 
-	err = json.Unmarshal(*data, out)
+	// every series needs the base labels, so the clients of the
+	// reference explorers do not report it
+	if labels := e.metricLabels(metrics.Label{Name: "method", Value: method}); labels != nil {
+		metrics.AddSampleWithLabels([]string{"rpc", "responseBytes"}, float32(body.n), labels)
+	}
+
+	return data, err
+}
+
+// batchCall is a single call of a batched request
+type batchCall struct {
+	method string
+	params interface{}
+	out    interface{}
+}
+
+var (
+	errBatchUnsupported = fmt.Errorf("batched requests not supported by the endpoint")
+	errBatchMismatch    = fmt.Errorf("batched response does not match the calls")
+)
+
+// rpcBatch sends all the calls in a single request. The returned map holds
+// the error of every call that failed. errBatchUnsupported is returned if
+// the endpoint answered with a single response object, errBatchMismatch
+// if the responses do not match the calls, which may be a transient
+// proxy error.
+func (e *EthClient) rpcBatch(calls []batchCall) (map[string]error, error) {
+	reqBody := []RPCRequest{}
+	for _, call := range calls {
+		params := call.params
+		if params == nil {
+			params = []interface{}{}
+		}
+
+		reqBody = append(reqBody, RPCRequest{
+			Id:      e.nextID(),
+			Jsonrpc: "2.0",
+			Method:  call.method,
+			Params:  params,
+		})
+
+		// providers bill every call of a batch
+		e.countCall(call.method)
+	}
+
+	data, err := e.post(e.context(), "batch", reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshall result: %v", err)
+		return nil, err
 	}
 
-	return err
+	var results []RPCResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		var single RPCResult
+		if json.Unmarshal(data, &single) == nil {
+			return nil, errBatchUnsupported
+		}
+		return nil, fmt.Errorf("failed to parse the batched response: %v", err)
+	}
+	if len(results) != len(calls) {
+		return nil, errBatchMismatch
+	}
+
+	// responses can come in any order
+	byID := map[int]RPCResult{}
+	for _, result := range results {
+		byID[result.ID] = result
+	}
+
+	errs := map[string]error{}
+	for indx, call := range calls {
+		result, ok := byID[reqBody[indx].Id]
+		if !ok {
+			e.idMismatch(call.method)
+			return nil, errBatchMismatch
+		}
+
+		if result.Error != nil {
+			errs[call.method] = result.Error
+			continue
+		}
+
+		if err := json.Unmarshal(result.Result, call.out); err != nil {
+			errs[call.method] = fmt.Errorf("failed to unmarshall result: %v", err)
+		}
+	}
+
+	return errs, nil
 }
 
-func ensureOk(resp *http.Response) (*json.RawMessage, error) {
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func readOk(resp *http.Response) ([]byte, error) {
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -130,9 +543,31 @@ func ensureOk(resp *http.Response) (*json.RawMessage, error) {
 		return nil, fmt.Errorf("status code %d different from 200: %s", resp.StatusCode, string(data))
 	}
 
+	return data, nil
+}
+
+func parseResult(data []byte) (*RPCResult, error) {
 	var res RPCResult
 
-	err = json.Unmarshal(data, &res)
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return &res, nil
+}
+
+func ensureOk(resp *http.Response) (*json.RawMessage, error) {
+	data, err := readOk(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := parseResult(data)
 	if err != nil {
 		return nil, err
 	}
@@ -149,6 +584,22 @@ func hexToBigInt(data string) (*big.Int, error) {
 	return big.NewInt(blockInt64), nil
 }
 
+// hexToBigIntUnbounded parses hex quantities that may not fit in an int64
+// (i.e. storage words)
+func hexToBigIntUnbounded(data string) (*big.Int, error) {
+	hex := strings.TrimPrefix(strings.TrimPrefix(data, "0x"), "0X")
+	if hex == "" {
+		return big.NewInt(0), nil
+	}
+
+	value, ok := big.NewInt(0).SetString(hex, 16)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse %s as hex", data)
+	}
+
+	return value, nil
+}
+
 func (e *EthClient) PeerCount() (int64, error) {
 	var peers string
 	if err := e.rpcCall("net_peerCount", nil, &peers); err != nil {
@@ -164,32 +615,512 @@ func (e *EthClient) Chain() (string, error) {
 	return chain, err
 }
 
+// ChainID returns the chain id of the node
+func (e *EthClient) ChainID() (*big.Int, error) {
+	var chainID string
+	if err := e.rpcCall("eth_chainId", nil, &chainID); err != nil {
+		return nil, err
+	}
+
+	return hexToBigInt(chainID)
+}
+
 func (e *EthClient) BlockNumber() (*big.Int, error) {
+	return e.BlockNumberContext(e.context())
+}
+
+func (e *EthClient) BlockNumberContext(ctx context.Context) (*big.Int, error) {
 	var block string
-	if err := e.rpcCall("eth_blockNumber", nil, &block); err != nil {
+	if err := e.rpcCallContext(ctx, "eth_blockNumber", nil, &block); err != nil {
 		return nil, err
 	}
 
 	return hexToBigInt(block)
 }
 
+// StorageAt returns the storage word at slot for address on the latest block
+func (e *EthClient) StorageAt(address, slot string) (*big.Int, error) {
+	var word string
+	if err := e.rpcCall("eth_getStorageAt", args(address, slot, "latest"), &word); err != nil {
+		return nil, err
+	}
+
+	return hexToBigIntUnbounded(word)
+}
+
+// Call executes a call to a contract on the latest block and returns the
+// first word of the result. A reverted call is an rpc error.
+func (e *EthClient) Call(address, data string) (*big.Int, error) {
+	call := map[string]string{
+		"to":   address,
+		"data": data,
+	}
+
+	var result string
+	if err := e.rpcCall("eth_call", args(call, "latest"), &result); err != nil {
+		return nil, err
+	}
+
+	word := strings.TrimPrefix(result, "0x")
+	if word == "" {
+		return nil, fmt.Errorf("empty result, %s may not be a contract", address)
+	}
+	if len(word) > 64 {
+		word = word[:64]
+	}
+
+	return hexToBigIntUnbounded(word)
+}
+
+// LogCount returns the number of logs emitted by address with the given
+// first topic between the from and to blocks, both included
+func (e *EthClient) LogCount(address, topic0 string, from, to *big.Int) (int, error) {
+	filter := map[string]interface{}{
+		"fromBlock": fmt.Sprintf("0x%x", from),
+		"toBlock":   fmt.Sprintf("0x%x", to),
+		"address":   address,
+		"topics":    []string{topic0},
+	}
+
+	var logs []json.RawMessage
+	if err := e.rpcCall("eth_getLogs", args(filter), &logs); err != nil {
+		return 0, err
+	}
+
+	return len(logs), nil
+}
+
+// BalanceAt returns the balance of address at the given block tag or number
+func (e *EthClient) BalanceAt(address, block string) (*big.Int, error) {
+	var balance string
+	if err := e.rpcCall("eth_getBalance", args(address, block), &balance); err != nil {
+		return nil, err
+	}
+
+	return hexToBigIntUnbounded(balance)
+}
+
+// IsArchive checks if the node still has the state of the first block.
+// The error is only returned if it can not be decided.
+func (e *EthClient) IsArchive() (bool, error) {
+	_, err := e.BalanceAt("0x0000000000000000000000000000000000000000", "0x1")
+	if err == nil {
+		return true, nil
+	}
+
+	if _, ok := err.(*RPCError); ok {
+		msg := strings.ToLower(err.Error())
+		for _, pruned := range []string{"missing trie node", "state not available", "pruning"} {
+			if strings.Contains(msg, pruned) {
+				return false, nil
+			}
+		}
+	}
+
+	return false, err
+}
+
+// Supports checks if the node implements the method. The call is made
+// without params, any rpc error other than method not found means the
+// method exists.
+func (e *EthClient) Supports(method string) (bool, error) {
+	var out json.RawMessage
+	err := e.rpcCall(method, nil, &out)
+	if err == nil {
+		return true, nil
+	}
+
+	rpcErr, ok := err.(*RPCError)
+	if !ok {
+		return false, err
+	}
+
+	if rpcErr.Code == -32601 {
+		return false, nil
+	}
+
+	// not every client uses the standard code
+	msg := strings.ToLower(rpcErr.Message)
+	for _, missing := range []string{"method not found", "does not exist", "not available", "not supported"} {
+		if strings.Contains(msg, missing) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// QueuedBlocks calls a client specific method that returns the number of
+// blocks waiting to be imported, either as a number or a hex string
+func (e *EthClient) QueuedBlocks(method string) (int64, error) {
+	queued, err := e.quantity(method)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshall queued blocks: %v", err)
+	}
+	return queued, nil
+}
+
+// DBSize calls a client specific method that returns the size of the
+// database in bytes, either as a number or a hex string
+func (e *EthClient) DBSize(method string) (int64, error) {
+	size, err := e.quantity(method)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshall database size: %v", err)
+	}
+	return size, nil
+}
+
+// quantity calls a method without params that returns a number or a hex
+// string
+func (e *EthClient) quantity(method string) (int64, error) {
+	var raw json.RawMessage
+	if err := e.rpcCall(method, nil, &raw); err != nil {
+		return 0, err
+	}
+
+	var hex string
+	if err := json.Unmarshal(raw, &hex); err == nil {
+		value, err := hexToBigInt(hex)
+		if err != nil {
+			return 0, err
+		}
+		return value.Int64(), nil
+	}
+
+	var value int64
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// ClientVersion returns the client name and version of the node
+func (e *EthClient) ClientVersion() (string, error) {
+	var version string
+	if err := e.rpcCall("web3_clientVersion", nil, &version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// clientFamily returns the client implementation of a client version
+// (i.e. "Geth/v1.13.5-stable/linux-amd64/go1.21.4" is geth)
+func clientFamily(version string) string {
+	name := strings.ToLower(strings.SplitN(version, "/", 2)[0])
+
+	switch {
+	case strings.HasPrefix(name, "geth"):
+		return "geth"
+	case strings.HasPrefix(name, "nethermind"):
+		return "nethermind"
+	case strings.HasPrefix(name, "besu"):
+		return "besu"
+	case strings.HasPrefix(name, "erigon"):
+		return "erigon"
+	case strings.HasPrefix(name, "openethereum"):
+		return "openethereum"
+	case strings.HasPrefix(name, "parity"):
+		return "parity"
+	}
+	return "other"
+}
+
+// TransactionCount returns the nonce of address at the given block tag
+func (e *EthClient) TransactionCount(address, block string) (*big.Int, error) {
+	var count string
+	if err := e.rpcCall("eth_getTransactionCount", args(address, block), &count); err != nil {
+		return nil, err
+	}
+
+	return hexToBigInt(count)
+}
+
+// SendSelfTransfer sends a zero value transfer from the address to itself
+// with the given nonce. The account must be unlocked in the node.
+func (e *EthClient) SendSelfTransfer(address string, nonce *big.Int) (string, error) {
+	tx := map[string]string{
+		"from":  address,
+		"to":    address,
+		"value": "0x0",
+		"nonce": fmt.Sprintf("0x%x", nonce),
+	}
+
+	var hash string
+	if err := e.rpcCall("eth_sendTransaction", args(tx), &hash); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// TransactionIncluded returns true once the transaction has a receipt
+func (e *EthClient) TransactionIncluded(hash string) (bool, error) {
+	var receipt *json.RawMessage
+	if err := e.rpcCall("eth_getTransactionReceipt", args(hash), &receipt); err != nil {
+		return false, err
+	}
+
+	return receipt != nil && string(*receipt) != "null", nil
+}
+
+type TxPoolStatus struct {
+	Pending int64
+	Queued  int64
+}
+
+// TxPoolStatus returns the number of transactions in the txpool
+func (e *EthClient) TxPoolStatus() (*TxPoolStatus, error) {
+	var raw struct {
+		Pending string `json:"pending"`
+		Queued  string `json:"queued"`
+	}
+	if err := e.rpcCall("txpool_status", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	pending, err := hexToBigInt(raw.Pending)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := hexToBigInt(raw.Queued)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxPoolStatus{Pending: pending.Int64(), Queued: queued.Int64()}, nil
+}
+
+// TransactionsLimit returns the max number of transactions in the
+// txpool. Parity only.
+func (e *EthClient) TransactionsLimit() (int64, error) {
+	var limit int64
+	if err := e.rpcCall("parity_transactionsLimit", nil, &limit); err != nil {
+		return 0, err
+	}
+	return limit, nil
+}
+
+type PendingTransaction struct {
+	Hash string `json:"hash"`
+	From string `json:"from"`
+}
+
+// PendingTransactionsFrom returns the pending transactions in the txpool
+// per sender, from a dump of the whole txpool
+func (e *EthClient) PendingTransactionsFrom() (map[string]int, error) {
+	var content struct {
+		Pending map[string]map[string]json.RawMessage `json:"pending"`
+	}
+	if err := e.rpcCall("txpool_content", nil, &content); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for from, txs := range content.Pending {
+		counts[strings.ToLower(from)] = len(txs)
+	}
+	return counts, nil
+}
+
+// PendingTransactions returns the transactions in the node txpool
+func (e *EthClient) PendingTransactions() ([]PendingTransaction, error) {
+	var txs []PendingTransaction
+	if err := e.rpcCall("parity_pendingTransactions", nil, &txs); err != nil {
+		return nil, err
+	}
+
+	return txs, nil
+}
+
+type Peer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Network struct {
+		LocalAddress  string `json:"localAddress"`
+		RemoteAddress string `json:"remoteAddress"`
+
+		// Not every client exposes the direction of the connection
+		Inbound *bool `json:"inbound"`
+	} `json:"network"`
+}
+
+type NetPeers struct {
+	Active    int    `json:"active"`
+	Connected int    `json:"connected"`
+	Max       int    `json:"max"`
+	Peers     []Peer `json:"peers"`
+}
+
+func (e *EthClient) NetPeers() (*NetPeers, error) {
+	var peers NetPeers
+	if err := e.rpcCall("parity_netPeers", nil, &peers); err != nil {
+		return nil, err
+	}
+
+	return &peers, nil
+}
+
+// AdminPeers requires the admin api to be enabled on the node
+func (e *EthClient) AdminPeers() ([]Peer, error) {
+	var peers []Peer
+	if err := e.rpcCall("admin_peers", nil, &peers); err != nil {
+		return nil, err
+	}
+
+	return peers, nil
+}
+
+type NodeInfo struct {
+	Enode      string `json:"enode"`
+	ListenAddr string `json:"listenAddr"`
+	Ports      struct {
+		Discovery int `json:"discovery"`
+		Listener  int `json:"listener"`
+	} `json:"ports"`
+}
+
+// NodeInfo requires the admin api to be enabled on the node
+func (e *EthClient) NodeInfo() (*NodeInfo, error) {
+	var info NodeInfo
+	if err := e.rpcCall("admin_nodeInfo", nil, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// GasPrice returns the gas price suggested by the node in wei
+func (e *EthClient) GasPrice() (*big.Int, error) {
+	var price string
+	if err := e.rpcCall("eth_gasPrice", nil, &price); err != nil {
+		return nil, err
+	}
+
+	return hexToBigIntUnbounded(price)
+}
+
+// SuggestedGasPrices computes suggested gas prices in wei from the fee
+// history of the latest blocks, one per reward percentile: the base fee of
+// the next block plus the average priority fee paid at the percentile.
+func (e *EthClient) SuggestedGasPrices(blocks int, percentiles []float64) ([]*big.Int, error) {
+	var history struct {
+		BaseFeePerGas []string   `json:"baseFeePerGas"`
+		Reward        [][]string `json:"reward"`
+	}
+	if err := e.rpcCall("eth_feeHistory", args(fmt.Sprintf("0x%x", blocks), "latest", percentiles), &history); err != nil {
+		return nil, err
+	}
+
+	// the last base fee is the one of the next block
+	if len(history.BaseFeePerGas) == 0 {
+		return nil, fmt.Errorf("empty fee history")
+	}
+	baseFee, err := hexToBigIntUnbounded(history.BaseFeePerGas[len(history.BaseFeePerGas)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	prices := []*big.Int{}
+	for indx := range percentiles {
+		sum := big.NewInt(0)
+		count := 0
+		for _, rewards := range history.Reward {
+			if indx >= len(rewards) {
+				continue
+			}
+			reward, err := hexToBigIntUnbounded(rewards[indx])
+			if err != nil {
+				return nil, err
+			}
+			sum.Add(sum, reward)
+			count++
+		}
+
+		price := new(big.Int).Set(baseFee)
+		if count != 0 {
+			price.Add(price, sum.Div(sum, big.NewInt(int64(count))))
+		}
+		prices = append(prices, price)
+	}
+
+	return prices, nil
+}
+
 type Block struct {
+	Number       *big.Int
+	Hash         string
+	Miner        string
 	Timestamp    *time.Time
 	Transactions int
 	GasLimit     *big.Int
+	GasUsed      *big.Int
+
+	// Uncles referenced by the block. Zero after the merge
+	Uncles int
+
+	// Only set if the block was fetched with the full transactions
+	TxSummary *TxSummary
+}
+
+// TxSummary aggregates the transactions of a block
+type TxSummary struct {
+	// Average gas price in wei
+	AvgGasPrice *big.Int
+
+	// Value transferred in wei
+	Value *big.Int
+
+	ContractCreations int
+}
+
+// PendingBlockNumber returns the number of the block being sealed. Some
+// clients do not support the pending tag or return a null block.
+func (e *EthClient) PendingBlockNumber() (*big.Int, error) {
+	return e.TaggedBlockNumber("pending")
 }
 
-func (e *EthClient) BlockByNumber(num *big.Int) (*Block, error) {
+// TaggedBlockNumber returns the number of the block of a tag (i.e. safe)
+func (e *EthClient) TaggedBlockNumber(tag string) (*big.Int, error) {
+	var block *struct {
+		Number *string `json:"number"`
+	}
+	if err := e.rpcCall("eth_getBlockByNumber", args(tag, false), &block); err != nil {
+		return nil, err
+	}
+
+	if block == nil || block.Number == nil {
+		return nil, fmt.Errorf("%s block not available", tag)
+	}
+
+	return hexToBigInt(*block.Number)
+}
+
+// BlockByNumber fetches the block with only the transaction hashes unless
+// fullTxs is set
+func (e *EthClient) BlockByNumber(num *big.Int, fullTxs bool) (*Block, error) {
 	hash := fmt.Sprintf("0x%x", num)
 
 	var result error
 
 	var raw map[string]interface{}
-	if err := e.rpcCall("eth_getBlockByNumber", args(hash, true), &raw); err != nil {
+	if err := e.rpcCall("eth_getBlockByNumber", args(hash, fullTxs), &raw); err != nil {
 		return nil, err
 	}
 
-	block := &Block{}
+	block := &Block{
+		Number: num,
+	}
+
+	if hash, ok := raw["hash"].(string); ok {
+		block.Hash = hash
+	}
+
+	// parity names it author
+	if miner, ok := raw["miner"].(string); ok {
+		block.Miner = miner
+	} else if author, ok := raw["author"].(string); ok {
+		block.Miner = author
+	}
 
 	if timestampHex, ok := raw["timestamp"]; ok {
 		timestamp, err := hexToBigInt(timestampHex.(string))
@@ -206,6 +1137,14 @@ func (e *EthClient) BlockByNumber(num *big.Int) (*Block, error) {
 	if transactionsRaw, ok := raw["transactions"]; ok {
 		if transactions, ok := transactionsRaw.([]interface{}); ok {
 			block.Transactions = len(transactions)
+
+			if fullTxs {
+				summary, err := summarizeTransactions(transactions)
+				if err != nil {
+					result = multierror.Append(result, err)
+				}
+				block.TxSummary = summary
+			}
 		} else {
 			result = multierror.Append(result, fmt.Errorf("Transaction field found but not an interface"))
 		}
@@ -213,6 +1152,10 @@ func (e *EthClient) BlockByNumber(num *big.Int) (*Block, error) {
 		result = multierror.Append(result, fmt.Errorf("transactions field not found"))
 	}
 
+	if uncles, ok := raw["uncles"].([]interface{}); ok {
+		block.Uncles = len(uncles)
+	}
+
 	if gasLimitRaw, ok := raw["gasLimit"]; ok {
 		gasLimit, err := hexToBigInt(gasLimitRaw.(string))
 		if err != nil {
@@ -224,7 +1167,61 @@ func (e *EthClient) BlockByNumber(num *big.Int) (*Block, error) {
 		result = multierror.Append(result, fmt.Errorf("gaslimit field not found"))
 	}
 
-	return block, nil
+	if gasUsedRaw, ok := raw["gasUsed"]; ok {
+		gasUsed, err := hexToBigInt(gasUsedRaw.(string))
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+
+		block.GasUsed = gasUsed
+	} else {
+		result = multierror.Append(result, fmt.Errorf("gasused field not found"))
+	}
+
+	return block, result
+}
+
+func summarizeTransactions(transactions []interface{}) (*TxSummary, error) {
+	summary := &TxSummary{
+		AvgGasPrice: big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	gasPrices := big.NewInt(0)
+
+	for _, txRaw := range transactions {
+		tx, ok := txRaw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("transaction is not an object")
+		}
+
+		if gasPriceHex, ok := tx["gasPrice"].(string); ok {
+			gasPrice, err := hexToBigIntUnbounded(gasPriceHex)
+			if err != nil {
+				return nil, err
+			}
+			gasPrices.Add(gasPrices, gasPrice)
+		}
+
+		if valueHex, ok := tx["value"].(string); ok {
+			value, err := hexToBigIntUnbounded(valueHex)
+			if err != nil {
+				return nil, err
+			}
+			summary.Value.Add(summary.Value, value)
+		}
+
+		// contract creations have no recipient
+		if tx["to"] == nil {
+			summary.ContractCreations++
+		}
+	}
+
+	if len(transactions) != 0 {
+		summary.AvgGasPrice.Div(gasPrices, big.NewInt(int64(len(transactions))))
+	}
+
+	return summary, nil
 }
 
 type RpcSync struct {
@@ -241,6 +1238,11 @@ func (e *EthClient) Syncing() (*RpcSync, error) {
 		return nil, err
 	}
 
+	return parseSyncing(raw)
+}
+
+// parseSyncing returns nil if the node is not syncing
+func parseSyncing(raw interface{}) (*RpcSync, error) {
 	_, ok := raw.(bool)
 	if ok {
 		return nil, nil
@@ -271,14 +1273,21 @@ func (e *EthClient) Syncing() (*RpcSync, error) {
 		return nil, fmt.Errorf("failed to parse starting block as big.Int: %s", res.HighestBlock)
 	}
 
-	warpChunksAmount, err := hexToBigInt(res.WarpChunksAmount)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse warpChunksAmount as big.Int: %s", res.HighestBlock)
+	// warp fields are empty unless the node is warp syncing
+	var warpChunksAmount, warpChunksProcessed *big.Int
+
+	if res.WarpChunksAmount != "" {
+		warpChunksAmount, err = hexToBigInt(res.WarpChunksAmount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse warpChunksAmount as big.Int: %s", res.HighestBlock)
+		}
 	}
 
-	warpChunksProcessed, err := hexToBigInt(res.WarpChunksProcessed)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse warpChunksProcessed as big.Int: %s", res.HighestBlock)
+	if res.WarpChunksProcessed != "" {
+		warpChunksProcessed, err = hexToBigInt(res.WarpChunksProcessed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse warpChunksProcessed as big.Int: %s", res.HighestBlock)
+		}
 	}
 
 	sync := &RpcSync{
@@ -291,3 +1300,160 @@ func (e *EthClient) Syncing() (*RpcSync, error) {
 
 	return sync, nil
 }
+
+// CycleResult holds the calls done on every gather cycle
+type CycleResult struct {
+	PeerCount   int64
+	BlockNumber *big.Int
+	Syncing     *RpcSync
+	GasPrice    *big.Int
+
+	// Errors of the calls that failed, by method
+	Errors map[string]error
+
+	// Calls of the batched request and how long it took. Zero if the
+	// calls were not batched
+	BatchSize    int
+	BatchLatency time.Duration
+}
+
+// Batch does the cycle calls in a single batched request. It falls back
+// to Sequential if the endpoint does not support batching, or for this
+// cycle only if the responses did not match the calls.
+func (e *EthClient) Batch() *CycleResult {
+	if e.batchUnsupported {
+		return e.Sequential()
+	}
+
+	var peers, block, gasPrice string
+	var syncing interface{}
+
+	calls := []batchCall{
+		{method: "net_peerCount", out: &peers},
+		{method: "eth_blockNumber", out: &block},
+		{method: "eth_syncing", out: &syncing},
+		{method: "eth_gasPrice", out: &gasPrice},
+	}
+
+	start := time.Now()
+	errs, err := e.rpcBatch(calls)
+	switch err {
+	case errBatchUnsupported:
+		e.batchUnsupported = true
+		return e.Sequential()
+	case errBatchMismatch:
+		return e.Sequential()
+	}
+
+	res := &CycleResult{
+		Errors:       map[string]error{},
+		BatchSize:    len(calls),
+		BatchLatency: time.Since(start),
+	}
+
+	if err != nil {
+		for _, method := range []string{"net_peerCount", "eth_blockNumber", "eth_syncing", "eth_gasPrice"} {
+			res.Errors[method] = err
+		}
+		return res
+	}
+
+	for method, err := range errs {
+		res.Errors[method] = err
+	}
+
+	if _, ok := res.Errors["net_peerCount"]; !ok {
+		if res.PeerCount, err = strconv.ParseInt(peers, 0, 64); err != nil {
+			res.Errors["net_peerCount"] = err
+		}
+	}
+	if _, ok := res.Errors["eth_blockNumber"]; !ok {
+		if res.BlockNumber, err = hexToBigInt(block); err != nil {
+			res.Errors["eth_blockNumber"] = err
+		}
+	}
+	if _, ok := res.Errors["eth_syncing"]; !ok {
+		if res.Syncing, err = parseSyncing(syncing); err != nil {
+			res.Errors["eth_syncing"] = err
+		}
+	}
+	if _, ok := res.Errors["eth_gasPrice"]; !ok {
+		if res.GasPrice, err = hexToBigIntUnbounded(gasPrice); err != nil {
+			res.Errors["eth_gasPrice"] = err
+		}
+	}
+
+	return res
+}
+
+// Sequential does the cycle calls one by one
+func (e *EthClient) Sequential() *CycleResult {
+	var err error
+
+	res := &CycleResult{
+		Errors: map[string]error{},
+	}
+
+	if res.PeerCount, err = e.PeerCount(); err != nil {
+		res.Errors["net_peerCount"] = err
+	}
+	if res.BlockNumber, err = e.BlockNumber(); err != nil {
+		res.Errors["eth_blockNumber"] = err
+	}
+	if res.Syncing, err = e.Syncing(); err != nil {
+		res.Errors["eth_syncing"] = err
+	}
+	if res.GasPrice, err = e.GasPrice(); err != nil {
+		res.Errors["eth_gasPrice"] = err
+	}
+
+	return res
+}
+
+// Concurrent does the cycle calls in parallel, at most limit at a time
+func (e *EthClient) Concurrent(limit int) *CycleResult {
+	res := &CycleResult{
+		Errors: map[string]error{},
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+
+	// every call sets its own field of the result
+	run := func(method string, call func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := call(); err != nil {
+				lock.Lock()
+				res.Errors[method] = err
+				lock.Unlock()
+			}
+		}()
+	}
+
+	run("net_peerCount", func() (err error) {
+		res.PeerCount, err = e.PeerCount()
+		return err
+	})
+	run("eth_blockNumber", func() (err error) {
+		res.BlockNumber, err = e.BlockNumber()
+		return err
+	})
+	run("eth_syncing", func() (err error) {
+		res.Syncing, err = e.Syncing()
+		return err
+	})
+	run("eth_gasPrice", func() (err error) {
+		res.GasPrice, err = e.GasPrice()
+		return err
+	})
+
+	wg.Wait()
+	return res
+}
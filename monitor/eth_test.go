@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// recordMetrics sends the metrics of the test to a recording sink
+func recordMetrics() *recordingSink {
+	conf := metrics.DefaultConfig("parity-pool")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	conf.EnableRuntimeMetrics = false
+
+	sink := &recordingSink{service: conf.ServiceName, emitted: map[string][]string{}}
+	metrics.NewGlobal(conf, sink)
+	return sink
+}
+
+func TestEthClientMetricLabels(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":999,"result":"0x1"}`)
+	}))
+	defer node.Close()
+
+	cases := []struct {
+		name     string
+		labels   []metrics.Label
+		expected string
+	}{
+		// sorted label names of the metric, none without the base labels
+		{"labelled", []metrics.Label{{Name: "node", Value: "test"}}, "method,node"},
+		{"explorer", nil, ""},
+	}
+
+	for _, c := range cases {
+		sink := recordMetrics()
+
+		client, err := NewEthClient(node.URL, DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.SetLabels(c.labels)
+
+		if _, err := client.BlockNumber(); err == nil {
+			t.Fatalf("%s: expected an id mismatch", c.name)
+		}
+
+		labels, ok := sink.labels("rpc.idMismatches")
+		if c.expected == "" {
+			if ok {
+				t.Fatalf("%s: expected no metric", c.name)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("%s: expected the metric", c.name)
+		}
+		if found := strings.Join(labels, ","); found != c.expected {
+			t.Fatalf("%s: expected the labels %s, found %s", c.name, c.expected, found)
+		}
+	}
+}
+
+func TestEthClientResponseTooLarge(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"0x%s"}`, strings.Repeat("0", 100))
+	}))
+	defer node.Close()
+
+	sink := recordMetrics()
+
+	config := DefaultConfig()
+	config.MaxResponseBytes = 64
+
+	client, err := NewEthClient(node.URL, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetLabels([]metrics.Label{{Name: "node", Value: "test"}})
+
+	if _, err := client.BlockNumber(); err == nil {
+		t.Fatal("expected a response too large")
+	}
+
+	labels, ok := sink.labels("rpc.responseTooLarge")
+	if !ok {
+		t.Fatal("expected the metric")
+	}
+	if found := strings.Join(labels, ","); found != "method,node" {
+		t.Fatalf("expected the labels method,node, found %s", found)
+	}
+}
@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	explorerEtherscan  = "etherscan"
+	explorerBlockscout = "blockscout"
+	explorerRPC        = "rpc"
+)
+
+// ExplorerConfig is a source of the reference block height
+type ExplorerConfig struct {
+	// Label of the explorer. Defaults to the host of the url
+	Name string `json:"name"`
+
+	// etherscan, blockscout or rpc. Defaults to etherscan
+	Type string `json:"type"`
+
+	// Api url of the explorer (i.e. https://api.etherscan.io/api) or
+	// the json-rpc endpoint
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+}
+
+// referenceSource returns the block height of the chain as seen by an
+// external explorer
+type referenceSource interface {
+	BlockNumber() (*big.Int, error)
+	BlockNumberContext(ctx context.Context) (*big.Int, error)
+}
+
+type referenceExplorer struct {
+	name   string
+	source referenceSource
+}
+
+func newReferenceExplorer(explorer ExplorerConfig, transport http.RoundTripper) (*referenceExplorer, error) {
+	u, err := url.Parse(explorer.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid explorer url '%s'", redactURL(explorer.URL))
+	}
+
+	name := explorer.Name
+	if name == "" {
+		name = u.Host
+	}
+
+	query := u.Query()
+	if explorer.APIKey != "" {
+		query.Set("apikey", explorer.APIKey)
+	}
+
+	var source referenceSource
+	switch strings.ToLower(explorer.Type) {
+	case "", explorerEtherscan:
+		query.Set("module", "proxy")
+		query.Set("action", "eth_blockNumber")
+		u.RawQuery = query.Encode()
+		source = newEtherscan(u.String(), transport)
+	case explorerBlockscout:
+		// etherscan compatible api, the result is a json-rpc response too
+		query.Set("module", "block")
+		query.Set("action", "eth_block_number")
+		u.RawQuery = query.Encode()
+		source = newEtherscan(u.String(), transport)
+	case explorerRPC:
+		source = newEthClient(explorer.URL, &Config{}, transport)
+	default:
+		return nil, fmt.Errorf("unknown explorer type '%s' for %s", explorer.Type, name)
+	}
+
+	return &referenceExplorer{name, source}, nil
+}
+
+// referenceBlockNumber returns the block height from the first explorer
+// that answers and its name. Every cycle starts again from the first one.
+// The context bounds the whole lookup.
+func referenceBlockNumber(ctx context.Context, explorers []*referenceExplorer) (*big.Int, string, error) {
+	var errs []string
+
+	for _, explorer := range explorers {
+		blockNumber, err := explorer.source.BlockNumberContext(ctx)
+		if err == nil {
+			return blockNumber, explorer.name, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", explorer.name, err))
+	}
+
+	return nil, "", fmt.Errorf("failed to get the reference block number: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// GraphiteSink is a MetricSink that writes the metrics to a Graphite
+// server using the plaintext protocol. The labels are sent as tags,
+// which requires Graphite 1.1 or later. Graphite has no metric types, the
+// counters are sent as the increment.
+type GraphiteSink struct {
+	*lineSink
+
+	addr   string
+	prefix string
+}
+
+// NewGraphiteSink creates a GraphiteSink that writes to the carbon
+// plaintext listener at addr (i.e. graphite:2003). The prefix, if any, is
+// prepended to every path.
+func NewGraphiteSink(logger *log.Logger, addr, prefix string) (*GraphiteSink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid graphite address '%s': %v", addr, err)
+	}
+
+	g := &GraphiteSink{
+		addr:   addr,
+		prefix: strings.TrimSuffix(prefix, "."),
+	}
+	g.lineSink = newLineSink("graphite", logger, g.formatLine, g.write)
+
+	return g, nil
+}
+
+// graphiteEscaper replaces the separators of the plaintext protocol
+var graphiteEscaper = strings.NewReplacer(" ", "_", ";", "_", "=", "_", "~", "_", "\n", "_")
+
+// formatLine formats a single point in the plaintext protocol. There is
+// a single value per path, so the field is not sent.
+func (g *GraphiteSink) formatLine(key []string, labels []metrics.Label, field string, val float32, ts time.Time) string {
+	var buf bytes.Buffer
+
+	path := graphiteEscaper.Replace(strings.Join(key, "."))
+	if g.prefix != "" {
+		path = g.prefix + "." + path
+	}
+	buf.WriteString(path)
+
+	for _, label := range labels {
+		if label.Value == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, ";%s=%s", graphiteEscaper.Replace(label.Name), graphiteEscaper.Replace(label.Value))
+	}
+	fmt.Fprintf(&buf, " %f %d\n", val, ts.Unix())
+
+	return buf.String()
+}
+
+func (g *GraphiteSink) write(data []byte) error {
+	conn, err := net.DialTimeout("tcp", g.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(data)
+	return err
+}
@@ -2,13 +2,22 @@ package monitor
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
+	metrics "github.com/armon/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type HttpServer struct {
@@ -17,6 +26,18 @@ type HttpServer struct {
 	HTTPAddr net.Addr
 	mux      *http.ServeMux
 	listener net.Listener
+
+	// Methods allowed on every registered path
+	methods map[string][]string
+
+	// Prometheus handlers of all the metrics and of the monitored
+	// node only
+	metrics     http.Handler
+	nodeMetrics http.Handler
+
+	// Time of the last scrape of the metrics endpoint
+	scrapeLock sync.Mutex
+	lastScrape time.Time
 }
 
 func NewHttpServer(logger *log.Logger, monitor *Monitor, HTTPAddr net.Addr) *HttpServer {
@@ -34,6 +55,16 @@ func (h *HttpServer) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start listner on %s: %v", h.HTTPAddr.String(), err)
 	}
 
+	// the bound port if the config asked for any
+	h.HTTPAddr = l.Addr()
+
+	if tlsConfig, err := newServerTLSConfig(h.monitor.config); err != nil {
+		l.Close()
+		return err
+	} else if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
 	go func() {
 		<-ctx.Done()
 		h.logger.Printf("Shutting down http server")
@@ -46,20 +77,119 @@ func (h *HttpServer) Start(ctx context.Context) error {
 	h.listener = l
 
 	h.mux = http.NewServeMux()
-	h.mux.Handle("/metrics", h.wrap(h.MetricsRequest))
-	h.mux.Handle("/synced", h.wrap(h.SyncedRequest))
+	metricsPath := h.monitor.config.MetricsPath
+
+	// gzip is used if the client accepts it
+	opts := promhttp.HandlerOpts{
+		DisableCompression: h.monitor.config.DisableMetricsCompression,
+	}
+
+	h.methods = map[string][]string{}
+
+	h.metrics = promhttp.HandlerFor(prometheus.DefaultGatherer, opts)
+	h.handle(metricsPath, h.MetricsRequest)
+	h.handle(path.Join(metricsPath, "names"), h.MetricNamesRequest)
+
+	// Metrics of a single node, for tenants that should not see the rest
+	if node := h.monitor.nodeLabel(); node != "" && node != "names" {
+		h.nodeMetrics = promhttp.HandlerFor(&nodeGatherer{prometheus.DefaultGatherer, node}, opts)
+		h.handle(path.Join(metricsPath, node), h.NodeMetricsRequest)
+	}
 
-	go http.Serve(l, h.mux)
+	h.handle(h.monitor.config.SyncedPath, h.SyncedRequest)
+	h.handle("/check", h.CheckRequest)
+	h.handle("/config", h.ConfigRequest)
+	h.handle("/livez", h.LivezRequest)
+	h.handle("/score", h.ScoreRequest)
+
+	// Probes the dependencies on demand. Disabled without a token
+	if h.monitor.config.SelfTestToken != "" {
+		h.handle("/selftest", h.SelfTestRequest)
+	}
+
+	go http.Serve(l, h.guard(h.mux))
 
 	h.logger.Printf("Http api running on %s", h.HTTPAddr.String())
 
 	return nil
 }
 
+// newServerTLSConfig returns the tls config of the http api. Nil if it is
+// served over plain http.
+func newServerTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load http api certificate: %v", err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// codedError is an error returned with a status code other than 500
+type codedError struct {
+	code int
+	err  error
+}
+
+func (c *codedError) Error() string {
+	return c.err.Error()
+}
+
+// handle registers an endpoint. Only GET is allowed unless other methods
+// are given.
+func (h *HttpServer) handle(pattern string, handler func(resp http.ResponseWriter, req *http.Request) (interface{}, error), methods ...string) {
+	if len(methods) == 0 {
+		methods = []string{"GET"}
+	}
+	h.methods[pattern] = methods
+	h.mux.Handle(pattern, h.wrap(handler))
+}
+
+// guard rejects the unknown paths and the methods not allowed on a path
+// before reaching the handlers, with a json error body
+func (h *HttpServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		_, pattern := h.mux.Handler(req)
+
+		methods, ok := h.methods[pattern]
+		if !ok {
+			writeJSONError(resp, http.StatusNotFound, fmt.Sprintf("Path %s not found", req.URL.Path))
+			return
+		}
+
+		for _, method := range methods {
+			if req.Method == method {
+				next.ServeHTTP(resp, req)
+				return
+			}
+		}
+
+		resp.Header().Set("Allow", strings.Join(methods, ", "))
+		writeJSONError(resp, http.StatusMethodNotAllowed, fmt.Sprintf("Incorrect method. Found %s, only %s available", req.Method, strings.Join(methods, ", ")))
+	})
+}
+
+func writeJSONError(resp http.ResponseWriter, code int, msg string) {
+	buf, _ := json.Marshal(map[string]string{"error": msg})
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	resp.Write(buf)
+}
+
 func (h *HttpServer) wrap(handler func(resp http.ResponseWriter, req *http.Request) (interface{}, error)) http.HandlerFunc {
 	return func(resp http.ResponseWriter, req *http.Request) {
 		handleErr := func(err error) {
-			resp.WriteHeader(http.StatusInternalServerError)
+			code := http.StatusInternalServerError
+			if codedErr, ok := err.(*codedError); ok {
+				code = codedErr.code
+			}
+
+			resp.WriteHeader(code)
 			resp.Write([]byte(err.Error()))
 		}
 
@@ -85,8 +215,11 @@ func (h *HttpServer) wrap(handler func(resp http.ResponseWriter, req *http.Reque
 }
 
 func (h *HttpServer) SyncedRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
-	if req.Method != "GET" {
-		return nil, fmt.Errorf("Incorrect method. Found %s, only GET available", req.Method)
+	if h.monitor.warmingUp() {
+		if h.monitor.connectFailed {
+			return nil, fmt.Errorf("Parity host unreachable")
+		}
+		return "warming up", nil
 	}
 
 	if !h.monitor.connected {
@@ -100,16 +233,131 @@ func (h *HttpServer) SyncedRequest(resp http.ResponseWriter, req *http.Request)
 	return nil, fmt.Errorf("Parity is not synced")
 }
 
-func (h *HttpServer) MetricsRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
-	if req.Method != "GET" {
-		return nil, fmt.Errorf("Incorrect method. Found %s, only GET available", req.Method)
+// CheckRequest follows the consul http check semantics: 200 is passing,
+// 429 is warning and anything else is critical
+func (h *HttpServer) CheckRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	critical := func(format string, a ...interface{}) error {
+		return &codedError{http.StatusServiceUnavailable, fmt.Errorf(format, a...)}
+	}
+
+	if !h.monitor.connected {
+		return nil, critical("Parity host unreachable")
+	}
+	if !h.monitor.synced {
+		return nil, critical("Parity is not synced")
+	}
+
+	peers := h.monitor.peers
+	if peers < int64(h.monitor.config.MinPeers) {
+		return nil, critical("Parity has %d peers, minimum is %d", peers, h.monitor.config.MinPeers)
+	}
+	if peers < int64(h.monitor.config.WarnPeers) {
+		return nil, &codedError{http.StatusTooManyRequests, fmt.Errorf("Parity has %d peers, warning under %d", peers, h.monitor.config.WarnPeers)}
+	}
+
+	return true, nil
+}
+
+// ScoreRequest returns the health score of the node with its breakdown
+func (h *HttpServer) ScoreRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return h.monitor.currentScore(), nil
+}
+
+// SelfTestRequest probes the dependencies of the exporter and returns a
+// report. It requires the SelfTestToken as a bearer token.
+func (h *HttpServer) SelfTestRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.monitor.config.SelfTestToken)) != 1 {
+		return nil, &codedError{http.StatusUnauthorized, fmt.Errorf("Invalid token")}
 	}
 
+	return h.monitor.selfTest(req.Context()), nil
+}
+
+// LivezRequest does a fresh rpc call instead of relying on the state of
+// the gather loop, which may be stuck
+func (h *HttpServer) LivezRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	client := h.monitor.currentEthClient()
+	if client == nil {
+		return nil, fmt.Errorf("Parity host unreachable")
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), h.monitor.config.LivenessTimeout)
+	defer cancel()
+
+	if _, err := client.BlockNumberContext(ctx); err != nil {
+		return nil, fmt.Errorf("Parity host not responding: %v", err)
+	}
+
+	return true, nil
+}
+
+func (h *HttpServer) MetricNamesRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return MetricNames(h.monitor.config), nil
+}
+
+func (h *HttpServer) ConfigRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return h.monitor.config.Redacted(), nil
+}
+
+func (h *HttpServer) MetricsRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	//if format := req.URL.Query().Get("format"); format == "prometheus" {
-	handler := promhttp.Handler()
-	handler.ServeHTTP(resp, req)
+	h.observeScrape()
+	h.metrics.ServeHTTP(resp, req)
 	return nil, nil
 	//}
 
 	return h.monitor.InmemSink.DisplayMetrics(resp, req)
 }
+
+// observeScrape exports the time since the previous scrape. It is set
+// before serving the metrics so the scrape gets the current value.
+func (h *HttpServer) observeScrape() {
+	h.scrapeLock.Lock()
+	defer h.scrapeLock.Unlock()
+
+	now := time.Now()
+	if !h.lastScrape.IsZero() {
+		metrics.SetGaugeWithLabels([]string{"scrape", "observedInterval"}, float32(now.Sub(h.lastScrape).Seconds()), h.monitor.currentBaseLabels())
+	}
+	h.lastScrape = now
+}
+
+func (h *HttpServer) NodeMetricsRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	h.nodeMetrics.ServeHTTP(resp, req)
+	return nil, nil
+}
+
+// nodeGatherer keeps only the series with the node label of one node.
+// The series without a node label (i.e. the runtime ones) are dropped.
+type nodeGatherer struct {
+	gatherer prometheus.Gatherer
+	node     string
+}
+
+func (n *nodeGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := n.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []*dto.MetricFamily{}
+	for _, family := range families {
+		metrics := []*dto.Metric{}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "node" && label.GetValue() == n.node {
+					metrics = append(metrics, metric)
+					break
+				}
+			}
+		}
+
+		if len(metrics) != 0 {
+			family.Metric = metrics
+			filtered = append(filtered, family)
+		}
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHttpMethods(t *testing.T) {
+	node := newStubNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.http.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := "http://" + m.http.HTTPAddr.String()
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/score", http.StatusOK},
+		{"GET", "/config", http.StatusOK},
+		{"POST", "/score", http.StatusMethodNotAllowed},
+		{"DELETE", "/config", http.StatusMethodNotAllowed},
+		{"POST", "/livez", http.StatusMethodNotAllowed},
+		{"GET", "/unknown", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, addr+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != c.code {
+			t.Fatalf("%s %s: expected %d, found %d", c.method, c.path, c.code, resp.StatusCode)
+		}
+	}
+
+	// the guard answers with a json error, the handlers are not reached
+	resp, err := http.Post(addr+"/score", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] == "" {
+		t.Fatal("expected an error")
+	}
+	if allow := resp.Header.Get("Allow"); allow != "GET" {
+		t.Fatalf("expected GET allowed, found %s", allow)
+	}
+}
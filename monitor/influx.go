@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// InfluxSink is a MetricSink that writes the metrics to an InfluxDB
+// server using the line protocol.
+type InfluxSink struct {
+	*lineSink
+
+	addr     string
+	database string
+	username string
+	password string
+
+	client *http.Client
+}
+
+// NewInfluxSink creates an InfluxSink that writes into database at addr
+func NewInfluxSink(logger *log.Logger, addr, database, username, password string) (*InfluxSink, error) {
+	if _, err := url.Parse(addr); err != nil {
+		return nil, fmt.Errorf("invalid influx url '%s': %v", addr, err)
+	}
+	if database == "" {
+		return nil, fmt.Errorf("influx database not set")
+	}
+
+	i := &InfluxSink{
+		addr:     strings.TrimRight(addr, "/"),
+		database: database,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	i.lineSink = newLineSink("influx", logger, formatInfluxLine, i.write)
+
+	return i, nil
+}
+
+var influxEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=")
+
+// formatInfluxLine formats a single point in the influx line protocol
+func formatInfluxLine(key []string, labels []metrics.Label, field string, val float32, ts time.Time) string {
+	var buf bytes.Buffer
+
+	buf.WriteString(influxEscaper.Replace(strings.Join(key, ".")))
+	for _, label := range labels {
+		if label.Value == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, ",%s=%s", influxEscaper.Replace(label.Name), influxEscaper.Replace(label.Value))
+	}
+	fmt.Fprintf(&buf, " %s=%f %d\n", field, val, ts.UnixNano())
+
+	return buf.String()
+}
+
+func (i *InfluxSink) write(data []byte) error {
+	query := url.Values{}
+	query.Set("db", i.database)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/write?%s", i.addr, query.Encode()), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if i.username != "" {
+		req.SetBasicAuth(i.username, i.password)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
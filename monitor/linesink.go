@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"bytes"
+	"log"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// lineFlushInterval is how often the buffered points are written
+	lineFlushInterval = 10 * time.Second
+
+	// lineQueueSize bounds the points waiting to be flushed. Points
+	// are dropped when the queue is full.
+	lineQueueSize = 4096
+)
+
+// lineFormatter formats a single point of a line protocol. The field is
+// "count" for the counters and "value" for the rest.
+type lineFormatter func(key []string, labels []metrics.Label, field string, val float32, ts time.Time) string
+
+// lineSink is a MetricSink that buffers the points of a line protocol and
+// writes them in batches. The backends only format and write the lines.
+type lineSink struct {
+	name   string
+	format lineFormatter
+	write  func(data []byte) error
+
+	logger      *log.Logger
+	metricQueue chan string
+
+	// Drops the gauges whose value did not change. Nil sends every value
+	onChange *changeFilter
+}
+
+// newLineSink starts flushing the points to write. The name of the
+// backend is only used in the logs.
+func newLineSink(name string, logger *log.Logger, format lineFormatter, write func(data []byte) error) *lineSink {
+	l := &lineSink{
+		name:        name,
+		format:      format,
+		write:       write,
+		logger:      logger,
+		metricQueue: make(chan string, lineQueueSize),
+	}
+
+	go l.flushMetrics()
+	return l
+}
+
+func (l *lineSink) SetGauge(key []string, val float32) {
+	l.SetGaugeWithLabels(key, val, nil)
+}
+
+func (l *lineSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	if l.onChange != nil && !l.onChange.changed(l.format(key, labels, "value", 0, time.Time{}), float64(val)) {
+		return
+	}
+	l.pushMetric(key, labels, "value", val)
+}
+
+func (l *lineSink) EmitKey(key []string, val float32) {
+	l.pushMetric(key, nil, "value", val)
+}
+
+func (l *lineSink) IncrCounter(key []string, val float32) {
+	l.IncrCounterWithLabels(key, val, nil)
+}
+
+func (l *lineSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	l.pushMetric(key, labels, "count", val)
+}
+
+func (l *lineSink) AddSample(key []string, val float32) {
+	l.AddSampleWithLabels(key, val, nil)
+}
+
+func (l *lineSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	l.pushMetric(key, labels, "value", val)
+}
+
+// pushMetric does a non-blocking push to the metrics queue
+func (l *lineSink) pushMetric(key []string, labels []metrics.Label, field string, val float32) {
+	select {
+	case l.metricQueue <- l.format(key, labels, field, val, time.Now()):
+	default:
+	}
+}
+
+func (l *lineSink) flushMetrics() {
+	var buf bytes.Buffer
+
+	ticker := time.NewTicker(lineFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line := <-l.metricQueue:
+			buf.WriteString(line)
+		case <-ticker.C:
+			if buf.Len() == 0 {
+				continue
+			}
+			if err := l.write(buf.Bytes()); err != nil {
+				l.logger.Printf("Failed to write metrics to %s: %v", l.name, err)
+			}
+			buf.Reset()
+		}
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
+	"math/rand"
 	"net"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	metrics "github.com/armon/go-metrics"
 	"github.com/armon/go-metrics/prometheus"
 	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/golang-lru/simplelru"
 )
 
 type Monitor struct {
@@ -24,66 +31,464 @@ type Monitor struct {
 	chain string
 
 	// Etherscan
-	etherscan *Etherscan
+	// Sources of the reference block height, in failover order
+	explorers []*referenceExplorer
 
 	// Ethereum client
 	ethClient *EthClient
 
+	// Transports of the ethereum client and of the explorers, shared by
+	// the clients created on every reconnect
+	rpcTransport      *http.Transport
+	explorerTransport *http.Transport
+
+	// Last resolved ips of the endpoints, kept for the process lifetime
+	dns *dnsCache
+
+	// Guards the fields replaced on reconnect that the http handlers
+	// read. The gather loop, their only writer, reads them without it
+	stateLock sync.RWMutex
+
+	// Circuit breaker of the ethereum client. Kept across the clients
+	// created on every reconnect so the failures are not forgotten
+	breaker *circuitBreaker
+
+	// Clients of the peer endpoints
+	peerClients []*EthClient
+
+	// Consensus client. Nil if not configured
+	beaconClient *BeaconClient
+
 	// Http server
 	http *HttpServer
 
+	// Remote write. Nil if not configured
+	remoteWriter *RemoteWriter
+
+	// State change notifications. Nil if not configured
+	webhook *Webhook
+
 	// Last block number
 	lastBlock *Block
 
+	// Gas price in gwei of the previous cycle and the smoothed change
+	// of the price per cycle. Nil until the first price
+	lastGasPrice *float32
+	gasTrendEma  float64
+
+	// Block number of the previous cycle and the consecutive cycles
+	// it has not changed
+	lastBlockNumber *big.Int
+	stalledCycles   int
+
+	// Current block of the previous syncing cycle, to compute the
+	// sync rate
+	lastSyncBlock *big.Int
+	lastSyncTime  time.Time
+
+	// Consecutive syncing cycles the current block has not changed
+	syncStalledCycles int
+
+	// Gas used of the latest heads. Ring buffer of FullnessWindow
+	fullness     []fullnessSample
+	fullnessNext int
+
+	// Miner of the latest heads, known miners or other
+	miners []string
+
+	// Transactions and block times of the latest heads, to smooth tps
+	tpsWindow []tpsSample
+
+	// Last block scanned for every event filter, by index
+	lastScannedBlock map[int]*big.Int
+
+	// Last block fetched with the full transactions
+	lastSampledBlock *big.Int
+
+	// Expected time between blocks for the connected chain
+	expectedBlockTime time.Duration
+
+	// Canary transaction in flight. Empty hash if there is none
+	canaryHash   string
+	canaryNonce  *big.Int
+	canarySentAt time.Time
+	canaryFailed bool
+
+	// Probed methods and whether the node implements them
+	capabilities map[string]bool
+
+	// Set once the node fails to return the pending block
+	pendingUnsupported bool
+
+	// Set once the node fails to dump the txpool content
+	txPoolContentUnsupported bool
+
+	// Whether the node keeps the historical state. Nil until probed
+	archive *bool
+
+	// First time each pending transaction of a watched address was seen
+	pendingSeen     map[string]time.Time
+	lastTxPoolCheck time.Time
+
+	// Last time the pending transactions of the watched addresses were
+	// counted
+	lastAccountPendingCheck time.Time
+
+	// Last time the database size was checked
+	lastDBSizeCheck time.Time
+
 	connected bool
 	synced    bool
 
+	// Set if the last attempt to connect to the node failed
+	connectFailed bool
+
+	// Time the connection to the node was lost. Zero if connected or
+	// never connected
+	disconnectedAt time.Time
+
+	// Time the monitor was created
+	startTime time.Time
+
+	// Peer count of the last cycle
+	peers     int64
+	peersSeen bool
+
+	// Blocks behind the reference of the last lookup. Nil until known
+	blocksBehind *int64
+
+	// Whether the latest gather cycles failed, for the health score
+	cycleFailures []bool
+
+	// Health score published by the gather loop after every cycle, so
+	// the http handlers do not read the state of the loop
+	scoreLock sync.Mutex
+	lastScore *Score
+
+	// Max peers reported by the node. Zero if unknown
+	maxPeers int
+
+	// Last time every peer id was seen, least recently seen first
+	seenPeers *simplelru.LRU
+
 	baseLabels []metrics.Label
+
+	// Client implementation of the node (i.e. geth). Empty until detected
+	client string
+
+	// Tracks the gather loop
+	wg sync.WaitGroup
+
+	// Last iteration of the gather loop and the current loop. A loop
+	// replaced by the watchdog has its context cancelled and the new one
+	// starts once loopDone is closed
+	heartbeatLock  sync.Mutex
+	heartbeat      time.Time
+	loopGeneration int
+	loopCancel     context.CancelFunc
+	loopDone       chan struct{}
+
+	// Held during a gather cycle
+	gatherLock sync.Mutex
+
+	// Why the monitor is stopping. Empty if the context was cancelled
+	// without a reason
+	shutdownLock   sync.Mutex
+	shutdownReason string
+
+	// Errors the monitor can not recover from. Buffered so reporting
+	// one never blocks
+	fatalCh chan error
 }
 
 func NewMonitor(config *Config) (*Monitor, error) {
 	m := &Monitor{
-		config:    config,
-		connected: false,
-		synced:    false,
+		config:           config,
+		connected:        false,
+		synced:           false,
+		pendingSeen:      map[string]time.Time{},
+		lastScannedBlock: map[int]*big.Int{},
+		startTime:        time.Now(),
+		fatalCh:          make(chan error, 1),
+		breaker:          newCircuitBreaker(config.RPCBreakerThreshold, config.RPCBreakerCoolDown),
 	}
 
 	m.logger = log.New(config.LogOutput, "", log.LstdFlags)
 
-	bindIP := net.ParseIP(config.BindAddr)
-	if bindIP == nil {
-		return nil, fmt.Errorf("Bind address '%s' is not a valid ip", bindIP)
+	endpoint, err := normalizeEndpoint(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	config.Endpoint = endpoint
+
+	m.setBaseLabels()
+
+	for _, path := range []string{config.MetricsPath, config.SyncedPath} {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("Http path '%s' must start with '/'", path)
+		}
+	}
+
+	// fail at startup instead of on every reconnect
+	m.dns = newDNSCache(config.DNSCacheTTL, m.logger, m.currentBaseLabels)
+	m.rpcTransport, err = newRPCTransport(config, m.dns)
+	if err != nil {
+		return nil, err
+	}
+	m.explorerTransport, err = newExplorerTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newServerTLSConfig(config); err != nil {
+		return nil, err
+	}
+
+	for indx, peer := range config.PeerEndpoints {
+		endpoint, err := normalizeEndpoint(peer)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid peer endpoint: %v", err)
+		}
+		config.PeerEndpoints[indx] = endpoint
+
+		transport, err := newRPCTransport(config, m.dns)
+		if err != nil {
+			return nil, err
+		}
+		client := newEthClient(endpoint, config, transport)
+		client.SetLabels(m.clientLabels(endpoint))
+		m.peerClients = append(m.peerClients, client)
+	}
+
+	for _, target := range config.WatchStorage {
+		if target.Address == "" || target.Metric == "" {
+			return nil, fmt.Errorf("Storage targets require an address and a metric name")
+		}
+	}
+
+	for _, call := range config.WatchCalls {
+		if call.Address == "" || call.CallData == "" || call.Metric == "" {
+			return nil, fmt.Errorf("Contract calls require an address, the call data and a metric name")
+		}
+		switch call.ResultDecoder {
+		case decodeUint, decodeInt, decodeBool:
+		default:
+			return nil, fmt.Errorf("Unknown result decoder '%s' of %s. Valid options are: %s, %s, %s", call.ResultDecoder, call.Metric, decodeUint, decodeInt, decodeBool)
+		}
+	}
+
+	if err := validateWatchMetrics(config); err != nil {
+		return nil, err
+	}
+
+	seenPeers, err := simplelru.NewLRU(config.MaxSeenPeers, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid max seen peers: %v", err)
+	}
+	m.seenPeers = seenPeers
+
+	switch config.PrimaryBlockTag {
+	case "", "latest", "safe", "finalized":
+	default:
+		return nil, fmt.Errorf("Unknown primary block tag '%s'. Valid options are: latest, safe, finalized", config.PrimaryBlockTag)
+	}
+
+	// the heartbeats are an interval apart on a healthy node
+	if config.WatchdogIntervals == 1 {
+		return nil, fmt.Errorf("Watchdog intervals must be at least 2")
+	}
+
+	switch config.WatchdogAction {
+	case "", watchdogExit, watchdogRestart:
+	default:
+		return nil, fmt.Errorf("Unknown watchdog action '%s'. Valid options are: %s, %s", config.WatchdogAction, watchdogExit, watchdogRestart)
+	}
+
+	if err := validateMetricAliases(config); err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, name := range optionalMetrics {
+		known[name] = true
+	}
+	for _, name := range config.DisabledMetrics {
+		if !known[name] {
+			return nil, fmt.Errorf("Unknown disabled metric '%s'. Valid options are: %s", name, strings.Join(optionalMetrics, ", "))
+		}
+	}
+
+	enabled := []string{}
+	for _, name := range optionalMetrics {
+		if m.enabled(name) {
+			enabled = append(enabled, name)
+		}
+	}
+	m.logger.Printf("Enabled metrics: %s", strings.Join(enabled, ", "))
+
+	for _, filter := range config.WatchEvents {
+		if filter.Address == "" || filter.Topic0 == "" || filter.Metric == "" {
+			return nil, fmt.Errorf("Event filters require an address, a topic and a metric name")
+		}
+	}
+
+	for _, explorer := range config.ReferenceExplorers {
+		if _, err := newReferenceExplorer(explorer, m.explorerTransport); err != nil {
+			return nil, err
+		}
+	}
+
+	bindIP, err := resolveBindIP(config.BindAddr)
+	if err != nil {
+		return nil, err
 	}
 
 	addr := &net.TCPAddr{IP: bindIP, Port: config.BindPort}
 
 	m.http = NewHttpServer(m.logger, m, addr)
 
-	go m.setupConsul()
+	if config.ConsensusEndpoint != "" {
+		m.beaconClient = NewBeaconClient(config.ConsensusEndpoint)
+	}
 
-	var err error
+	if config.RemoteWriteURL != "" {
+		m.remoteWriter = NewRemoteWriter(config.RemoteWriteURL)
+		if config.EmitOnChangeOnly {
+			m.remoteWriter.onChange = newChangeFilter()
+		}
+	}
+
+	if config.WebhookURL != "" {
+		if u, err := url.Parse(config.WebhookURL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("Invalid webhook url")
+		}
+		m.webhook = NewWebhook(config.WebhookURL, m.logger)
+	}
+
+	if config.ConsulConfig.IsEnabled() {
+		if err := config.ConsulConfig.Validate(config.NodeName); err != nil {
+			return nil, fmt.Errorf("invalid consul config: %v", err)
+		}
+	}
 
 	m.InmemSink, err = m.setupTelemetry()
 	if err != nil {
 		return nil, err
 	}
 
+	// after the telemetry so the registration metrics are not lost
+	if config.ConsulConfig.IsEnabled() {
+		go m.setupConsul()
+	}
+
 	return m, nil
 }
 
+// resolveBindIP accepts either an ip or a hostname (i.e. "localhost")
+// and returns the ip the http server should listen on.
+func resolveBindIP(bindAddr string) (net.IP, error) {
+	if ip := net.ParseIP(bindAddr); ip != nil {
+		return ip, nil
+	}
+
+	addr, err := net.ResolveIPAddr("ip", bindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Bind address '%s' is not a valid ip or hostname: %v", bindAddr, err)
+	}
+
+	return addr.IP, nil
+}
+
 func (m *Monitor) setBaseLabels() {
-	m.baseLabels = []metrics.Label{}
+	labels := []metrics.Label{}
+
+	nodeName := m.config.NodeName
+	if nodeName == "" {
+		// default to the host of the endpoint
+		if u, err := url.Parse(m.config.Endpoint); err == nil {
+			nodeName = u.Hostname()
+		}
+	}
 
-	m.baseLabels = append(m.baseLabels, metrics.Label{
+	labels = append(labels, metrics.Label{
 		Name:  "node",
-		Value: m.config.NodeName,
+		Value: nodeName,
+	})
+
+	// always set, every series of a metric must have the same labels
+	client := m.client
+	if client == "" {
+		client = "unknown"
+	}
+	labels = append(labels, metrics.Label{
+		Name:  "client",
+		Value: client,
 	})
+
+	m.stateLock.Lock()
+	m.baseLabels = labels
+	m.stateLock.Unlock()
+
+	if m.ethClient != nil {
+		m.ethClient.SetLabels(m.clientLabels(m.config.Endpoint))
+	}
+	for indx, client := range m.peerClients {
+		client.SetLabels(m.clientLabels(m.config.PeerEndpoints[indx]))
+	}
+}
+
+// clientLabels returns the labels of the rpc metrics of the client of the
+// endpoint. The endpoint keeps the peer endpoints apart from the node.
+func (m *Monitor) clientLabels(endpoint string) []metrics.Label {
+	return m.labels(metrics.Label{Name: "endpoint", Value: redactURL(endpoint)})
+}
+
+// nodeLabel returns the value of the node label
+func (m *Monitor) nodeLabel() string {
+	for _, label := range m.baseLabels {
+		if label.Name == "node" {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// currentBaseLabels returns a copy of the base labels for the http
+// handlers
+func (m *Monitor) currentBaseLabels() []metrics.Label {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+
+	return append([]metrics.Label(nil), m.baseLabels...)
+}
+
+// labels returns the base labels plus the extra ones
+func (m *Monitor) labels(extra ...metrics.Label) []metrics.Label {
+	labels := make([]metrics.Label, 0, len(m.baseLabels)+len(extra))
+	labels = append(labels, m.baseLabels...)
+	return append(labels, extra...)
 }
 
-func (m *Monitor) setupApis() error {
+// unknownChainError is returned by setupApis if the node runs a chain
+// without a reference explorer
+type unknownChainError struct {
+	chain string
+}
+
+func (u *unknownChainError) Error() string {
+	return fmt.Sprintf("Chain %s not found. 'kovan' and 'foundation' are the only valid options", u.chain)
+}
+
+func (m *Monitor) setupApis(ctx context.Context) error {
 
 	// api
-	m.ethClient = NewEthClient(m.config.Endpoint)
+	ethClient := newEthClient(m.config.Endpoint, m.config, m.rpcTransport)
+	ethClient.SetContext(ctx)
+	ethClient.breaker = m.breaker
+	ethClient.SetLabels(m.clientLabels(m.config.Endpoint))
+
+	m.stateLock.Lock()
+	m.ethClient = ethClient
+	m.stateLock.Unlock()
 
 	chain, err := m.ethClient.Chain()
 	if err != nil {
@@ -92,30 +497,151 @@ func (m *Monitor) setupApis() error {
 
 	// etherscan
 	var url string
+	var chainID int
+	var blockTime time.Duration
 	switch chain {
 	case "kovan":
 		url = "https://kovan.etherscan.io/api?module=proxy&action=eth_blockNumber"
+		chainID = 42
+		blockTime = 4 * time.Second
 	case "foundation":
 		url = "https://api.etherscan.io/api?module=proxy&action=eth_blockNumber"
+		chainID = 1
+		blockTime = 15 * time.Second
 	default:
-		return fmt.Errorf("Chain %s not found. 'kovan' and 'foundation' are the only valid options", chain)
+		// the explorers may cover chains etherscan does not
+		if len(m.config.ReferenceExplorers) == 0 {
+			return &unknownChainError{chain}
+		}
+	}
+
+	if m.config.EtherscanV2 {
+		// single endpoint for every chain
+		url = fmt.Sprintf("https://api.etherscan.io/v2/api?chainid=%d&module=proxy&action=eth_blockNumber", chainID)
+	}
+	if m.config.EtherscanAPIKey != "" {
+		url += "&apikey=" + m.config.EtherscanAPIKey
 	}
 
 	m.logger.Printf("Using chain %s", chain)
-	m.etherscan = NewEtherscan(url)
+	m.chain = chain
+
+	var explorers []*referenceExplorer
+	if len(m.config.ReferenceExplorers) == 0 {
+		explorers = []*referenceExplorer{{"etherscan", newEtherscan(url, m.explorerTransport)}}
+	} else {
+		for _, config := range m.config.ReferenceExplorers {
+			explorer, err := newReferenceExplorer(config, m.explorerTransport)
+			if err != nil {
+				return err
+			}
+			explorers = append(explorers, explorer)
+		}
+	}
+
+	m.stateLock.Lock()
+	m.explorers = explorers
+	m.stateLock.Unlock()
+
+	m.expectedBlockTime = blockTime
+	if m.config.ExpectedBlockTime != 0 {
+		m.expectedBlockTime = m.config.ExpectedBlockTime
+	}
+
+	// detected on every connect since a load balancer may serve
+	// a different client now
+	m.client = ""
+	if version, err := m.ethClient.ClientVersion(); err != nil {
+		m.logger.Printf("[WARN] Failed to get the client version: %v", err)
+	} else {
+		m.client = clientFamily(version)
+	}
+	m.setBaseLabels()
+
+	// the endpoint may be a different node now
+	m.archive = nil
+	m.pendingUnsupported = false
+	m.txPoolContentUnsupported = false
+	m.probeCapabilities()
+
+	return nil
+}
+
+// checkChainID compares the chain id of the node with the configured one
+func (m *Monitor) checkChainID() error {
+	chainID, err := m.ethClient.ChainID()
+	if err != nil {
+		return err
+	}
+
+	mismatch := 0
+	if chainID.Cmp(big.NewInt(m.config.ExpectedChainID)) != 0 {
+		mismatch = 1
+		m.logger.Printf("[ERR] Node reports chain id %s, expected %d", chainID, m.config.ExpectedChainID)
+	}
+	metrics.SetGaugeWithLabels([]string{"chain", "idMismatch"}, float32(mismatch), m.baseLabels)
 
 	return nil
 }
 
+// probeCapabilities checks once per connection which of the probe
+// methods the node implements
+func (m *Monitor) probeCapabilities() {
+	m.capabilities = map[string]bool{}
+
+	methods := append([]string{}, m.config.ProbeMethods...)
+	if m.config.SyncQueueMethod != "" {
+		methods = append(methods, m.config.SyncQueueMethod)
+	}
+	if m.config.DBSizeMethod != "" {
+		methods = append(methods, m.config.DBSizeMethod)
+	}
+
+	for _, method := range methods {
+		supported, err := m.ethClient.Supports(method)
+		if err != nil {
+			// unknown, the calls are still attempted
+			m.logger.Printf("[WARN] Failed to probe %s: %v", method, err)
+			continue
+		}
+		m.capabilities[method] = supported
+
+		value := 0
+		if supported {
+			value = 1
+		}
+		metrics.SetGaugeWithLabels([]string{"capabilities"}, float32(value), m.labels(
+			metrics.Label{Name: "method", Value: method},
+		))
+	}
+}
+
+// supports returns false only if the method was probed and the node
+// does not implement it
+func (m *Monitor) supports(method string) bool {
+	supported, ok := m.capabilities[method]
+	return !ok || supported
+}
+
 func (m *Monitor) setupTelemetry() (*metrics.InmemSink, error) {
 	// Prepare metrics
 
-	memSink := metrics.NewInmemSink(10*time.Second, time.Minute)
+	memSink := metrics.NewInmemSink(m.config.InmemInterval, m.config.InmemRetain)
 	metrics.DefaultInmemSignal(memSink)
 
 	metricsConf := metrics.DefaultConfig("parity-pool")
 	metricsConf.EnableHostnameLabel = true
 
+	// goroutines, memory and gc pauses of the exporter. The prometheus
+	// default registry exports the go_* metrics as well
+	metricsConf.EnableRuntimeMetrics = true
+
+	if m.config.EnableHostnameLabel != nil && !*m.config.EnableHostnameLabel {
+		// otherwise the hostname is used as a prefix of the keys
+		metricsConf.EnableHostnameLabel = false
+		metricsConf.EnableHostname = false
+	}
+
 	var sinks metrics.FanoutSink
 
 	prom, err := prometheus.NewPrometheusSink()
@@ -125,50 +651,123 @@ func (m *Monitor) setupTelemetry() (*metrics.InmemSink, error) {
 
 	sinks = append(sinks, prom)
 
+	if m.config.InfluxURL != "" {
+		influx, err := NewInfluxSink(m.logger, m.config.InfluxURL, m.config.InfluxDB, m.config.InfluxUsername, m.config.InfluxPassword)
+		if err != nil {
+			return nil, err
+		}
+		if m.config.EmitOnChangeOnly {
+			influx.onChange = newChangeFilter()
+		}
+
+		sinks = append(sinks, influx)
+	}
+
+	if m.config.GraphiteAddr != "" {
+		graphite, err := NewGraphiteSink(m.logger, m.config.GraphiteAddr, m.config.GraphitePrefix)
+		if err != nil {
+			return nil, err
+		}
+		if m.config.EmitOnChangeOnly {
+			graphite.onChange = newChangeFilter()
+		}
+
+		sinks = append(sinks, graphite)
+	}
+
 	if len(sinks) > 0 {
 		sinks = append(sinks, memSink)
-		metrics.NewGlobal(metricsConf, sinks)
+		metrics.NewGlobal(metricsConf, newAliasSink(sinks, metricsConf.ServiceName, m.config.MetricAliases))
 	} else {
 		metricsConf.EnableHostname = false
-		metrics.NewGlobal(metricsConf, memSink)
+		metrics.NewGlobal(metricsConf, newAliasSink(memSink, metricsConf.ServiceName, m.config.MetricAliases))
 	}
 
 	return memSink, nil
 }
 
+// localDatacenter is the datacenter label of the agent registration
+const localDatacenter = "local"
+
 func (m *Monitor) setupConsul() {
 	retries := 5
 	sleepDuration := 1 * time.Minute
 
+	// the agent datacenter first, then the extra ones
+	pending := append([]string{""}, m.config.ConsulConfig.Datacenters...)
+
+	for _, datacenter := range pending {
+		metrics.SetGaugeWithLabels([]string{"consul", "registered"}, 0, m.consulLabels(datacenter))
+	}
+
 	for i := 0; i < retries; i++ {
-		err := m.setupConsulImpl()
-		if err == nil {
-			m.logger.Printf("Service registred in consul")
-			return
+		failed := []string{}
+		for _, datacenter := range pending {
+			metrics.IncrCounterWithLabels([]string{"consul", "registrationAttempts"}, 1, m.consulLabels(datacenter))
+
+			if err := m.setupConsulImpl(datacenter); err != nil {
+				m.logger.Printf("Failed to register in consul datacenter %s: %v", consulDatacenterName(datacenter), err)
+				failed = append(failed, datacenter)
+				continue
+			}
+
+			m.logger.Printf("Service registred in consul datacenter %s", consulDatacenterName(datacenter))
+			metrics.SetGaugeWithLabels([]string{"consul", "registered"}, 1, m.consulLabels(datacenter))
 		}
 
-		m.logger.Printf("Failed to connect to consul: %v", err)
+		pending = failed
+		if len(pending) == 0 {
+			return
+		}
 		time.Sleep(sleepDuration)
 	}
 
 	m.logger.Printf("Stop trying to register on consul")
 }
 
-func (m *Monitor) setupConsulImpl() error {
-	serviceID := fmt.Sprintf(m.config.NodeName)
+func consulDatacenterName(datacenter string) string {
+	if datacenter == "" {
+		return localDatacenter
+	}
+	return datacenter
+}
+
+func (m *Monitor) consulLabels(datacenter string) []metrics.Label {
+	return m.labels(metrics.Label{Name: "datacenter", Value: consulDatacenterName(datacenter)})
+}
+
+// setupConsulImpl registers the service in the datacenter. An empty
+// datacenter registers it with the agent, along with the health check.
+func (m *Monitor) setupConsulImpl(datacenter string) error {
+	if err := m.config.ConsulConfig.Validate(m.config.NodeName); err != nil {
+		return err
+	}
+
+	serviceID := m.config.NodeName
 
 	// address
 	healthAddr := fmt.Sprintf("%s:%d", m.config.BindAddr, m.config.BindPort)
 
+	scheme := "http"
+	if m.config.TLSCertFile != "" {
+		scheme = "https"
+	}
+
+	checkPath := m.config.ConsulConfig.CheckPath
+	if checkPath == "" {
+		checkPath = m.config.SyncedPath
+	}
+
 	service := &consulapi.AgentServiceRegistration{
 		ID:   serviceID,
 		Name: m.config.ConsulConfig.ServiceName,
 		Tags: m.config.ConsulConfig.Tags,
 		Port: 8545,
 		Check: &consulapi.AgentServiceCheck{
-			HTTP:     fmt.Sprintf("http://%s/synced", healthAddr),
-			Interval: "1s",
-			Timeout:  "5s",
+			HTTP:          fmt.Sprintf("%s://%s%s", scheme, healthAddr, checkPath),
+			Interval:      "1s",
+			Timeout:       "5s",
+			TLSSkipVerify: m.config.ConsulConfig.TLSSkipVerify,
 		},
 	}
 
@@ -180,10 +779,56 @@ func (m *Monitor) setupConsulImpl() error {
 		return err
 	}
 
-	if err := client.Agent().ServiceRegister(service); err != nil {
+	if err := m.checkConsulConflict(client, service, datacenter); err != nil {
+		if !m.config.ConsulConfig.AllowOverwrite {
+			return err
+		}
+		m.logger.Printf("Overwriting consul registration: %v", err)
+	}
+
+	if datacenter == "" {
+		return client.Agent().ServiceRegister(service)
+	}
+
+	// the agent only registers in its own datacenter
+	nodeName, err := client.Agent().NodeName()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Catalog().Register(&consulapi.CatalogRegistration{
+		Node:       nodeName,
+		Address:    m.config.BindAddr,
+		Datacenter: datacenter,
+		Service: &consulapi.AgentService{
+			ID:      service.ID,
+			Service: service.Name,
+			Tags:    service.Tags,
+			Port:    service.Port,
+		},
+	}, nil)
+	return err
+}
+
+// checkConsulConflict returns an error if the service id is already
+// registered in the datacenter by a node other than the local agent
+func (m *Monitor) checkConsulConflict(client *consulapi.Client, service *consulapi.AgentServiceRegistration, datacenter string) error {
+	nodeName, err := client.Agent().NodeName()
+	if err != nil {
+		return err
+	}
+
+	services, _, err := client.Catalog().Service(service.Name, "", &consulapi.QueryOptions{Datacenter: datacenter})
+	if err != nil {
 		return err
 	}
 
+	for _, s := range services {
+		if s.ServiceID == service.ID && s.Node != nodeName {
+			return fmt.Errorf("service id '%s' already registered by node %s (%s)", service.ID, s.Node, s.Address)
+		}
+	}
+
 	return nil
 }
 
@@ -195,6 +840,16 @@ func Sub(x, y *big.Int) *big.Int {
 	return big.NewInt(0).Sub(x, y)
 }
 
+func weiToGwei(x *big.Int) float32 {
+	gwei, _ := new(big.Float).Quo(new(big.Float).SetInt(x), big.NewFloat(1e9)).Float32()
+	return gwei
+}
+
+// warmingUp returns true during the startup grace period
+func (m *Monitor) warmingUp() bool {
+	return time.Since(m.startTime) < m.config.StartupGracePeriod
+}
+
 func (m *Monitor) Start(ctx context.Context) error {
 	m.logger.Println("Staring monitor")
 
@@ -202,103 +857,1741 @@ func (m *Monitor) Start(ctx context.Context) error {
 		return err
 	}
 
-	go m.start(ctx)
+	if m.config.StartupWait > 0 {
+		m.waitForNode(ctx, m.config.StartupWait)
+	}
+
+	m.publishScore()
+	m.startLoop(ctx)
+
+	if m.config.WatchdogIntervals > 0 {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.watchdog(ctx)
+		}()
+	}
+
 	return nil
 }
 
-func (m *Monitor) start(ctx context.Context) {
+// currentEthClient returns the ethereum client from outside the gather
+// loop. Nil until the first connect.
+func (m *Monitor) currentEthClient() *EthClient {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+
+	return m.ethClient
+}
+
+// startLoop starts a new gather loop. The previous loop, if any, must
+// have stopped.
+func (m *Monitor) startLoop(ctx context.Context) {
+	loopCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	m.heartbeatLock.Lock()
+	m.loopCancel = cancel
+	m.loopDone = done
+	m.loopGeneration++
+	generation := m.loopGeneration
+	m.heartbeat = time.Now()
+	m.heartbeatLock.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(done)
+
+		err := m.start(loopCtx, generation)
+		if err == nil && ctx.Err() == nil {
+			m.logger.Printf("Gather loop stopped by the watchdog")
+			return
+		}
+
+		m.shutdown(ctx, err)
+		if err != nil {
+			m.fatal(err)
+		}
+	}()
+}
+
+// restartLoop cancels the context of the gather loop and starts a new
+// one once it stopped. Returns false if the monitor stopped meanwhile.
+func (m *Monitor) restartLoop(ctx context.Context) bool {
+	m.heartbeatLock.Lock()
+	cancel, done := m.loopCancel, m.loopDone
+	m.heartbeatLock.Unlock()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return false
+	}
+
+	m.startLoop(ctx)
+	return true
+}
+
+// beat records an iteration of the gather loop
+func (m *Monitor) beat() {
+	m.heartbeatLock.Lock()
+	defer m.heartbeatLock.Unlock()
+
+	m.heartbeat = time.Now()
+}
+
+// watchdogTimeout is the time without a gather loop iteration after
+// which the watchdog trips
+func (m *Monitor) watchdogTimeout() time.Duration {
+	return time.Duration(m.config.WatchdogIntervals) * m.config.RPCInterval
+}
+
+// watchdog checks the gather loop keeps iterating. A hung loop either
+// stops the exporter or is replaced, depending on WatchdogAction.
+func (m *Monitor) watchdog(ctx context.Context) {
+	timeout := m.watchdogTimeout()
+
+	ticker := time.NewTicker(m.config.RPCInterval)
+	defer ticker.Stop()
 
-	// gather metrics
 	for {
 		select {
-		case <-time.After(m.config.RPCInterval):
-
-			if m.connected {
-				previousState := m.synced
+		case <-ticker.C:
+			m.heartbeatLock.Lock()
+			elapsed := time.Since(m.heartbeat)
+			m.heartbeatLock.Unlock()
 
-				// RPC calls
-				if err := m.gatherMetrics(); err != nil {
-					m.logger.Printf("Export errors: %v", err)
+			if elapsed < timeout {
+				continue
+			}
 
-					if strings.Contains(err.Error(), "connection refused") { // TODO. Add fallback strategy
-						m.logger.Printf("Node may be down")
-						m.connected = false
-					}
+			metrics.IncrCounterWithLabels([]string{"gather", "watchdogTripped"}, 1, m.baseLabels)
+			m.logger.Printf("[ERR] Gather loop hung, no iteration for %s", elapsed.Round(time.Second))
 
-					if previousState != m.synced {
-						fmt.Printf("State changed. Is Synced?: %v\n", m.synced)
-					}
+			if m.config.WatchdogAction == watchdogRestart {
+				// the pending calls fail once the context is cancelled, the
+				// new loop starts after the old one stopped
+				m.logger.Printf("Restarting the gather loop")
+				if !m.restartLoop(ctx) {
+					return
 				}
+				continue
+			}
 
-			} else {
+			err := fmt.Errorf("gather loop hung for %s", elapsed.Round(time.Second))
+			m.shutdown(ctx, err)
+			m.fatal(err)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-				// setup APIS
-				if err := m.setupApis(); err != nil {
-					m.logger.Printf("Failed to connect to node: %v", err)
-				} else {
-					m.logger.Printf("Chain connected. Gathering metrics...")
-					m.connected = true
-				}
+// waitForNode blocks until the node answers or the timeout expires. The
+// gather loop keeps trying to connect if it does not answer in time.
+func (m *Monitor) waitForNode(ctx context.Context, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := m.setupApis(ctx)
+		if err == nil {
+			m.logger.Printf("Chain connected. Gathering metrics...")
+			m.connected = true
+			m.connectionTransition(false)
+			return
+		}
+
+		if time.Now().After(deadline) {
+			m.logger.Printf("Node not ready after %s, starting anyway: %v", timeout, err)
+			return
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Fatal returns the error that stopped the gather loop on its own. The
+// context passed to Start should be cancelled once it is received.
+func (m *Monitor) Fatal() <-chan error {
+	return m.fatalCh
+}
+
+// fatal reports an error the monitor can not recover from. It does not
+// block, only the first error is kept if nobody is receiving anymore.
+func (m *Monitor) fatal(err error) {
+	select {
+	case m.fatalCh <- err:
+	default:
+	}
+}
+
+// SetShutdownReason records why the context passed to Start is about to
+// be cancelled (i.e. the signal received). Logged when the loop stops.
+func (m *Monitor) SetShutdownReason(reason string) {
+	m.shutdownLock.Lock()
+	defer m.shutdownLock.Unlock()
+
+	m.shutdownReason = reason
+}
+
+// shutdown logs the shutdown event with the reason and the uptime. A
+// clean shutdown is one requested through SetShutdownReason.
+func (m *Monitor) shutdown(ctx context.Context, fatal error) {
+	m.shutdownLock.Lock()
+	reason := m.shutdownReason
+	m.shutdownLock.Unlock()
+
+	clean := reason != "" && fatal == nil
+	if fatal != nil {
+		reason = fmt.Sprintf("fatal: %v", fatal)
+	} else if reason == "" {
+		reason = fmt.Sprintf("context: %v", ctx.Err())
+	}
+
+	m.logger.Printf("Monitor shutting down: event=shutdown reason=%q clean=%v uptime=%s",
+		reason, clean, time.Since(m.startTime).Round(time.Second))
+}
+
+// Wait blocks until the gather loop stops after the context passed to
+// Start is done, or the timeout expires
+func (m *Monitor) Wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout waiting for the monitor to stop")
+	}
+}
+
+// start runs the gather loop until its context is done. Returns the
+// error the monitor can not recover from, if any.
+func (m *Monitor) start(ctx context.Context, generation int) error {
+
+	// Offsets the cycles of every instance so a fleet does not hit a
+	// shared provider at the same time. Only the first loop, a loop
+	// started by the watchdog is already late
+	if m.config.IntervalJitter && generation == 1 {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		jitter := time.Duration(random.Int63n(int64(m.config.RPCInterval)))
+
+		select {
+		case <-time.After(jitter):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	lastIteration := time.Now()
+
+	referenceTicker := time.NewTicker(m.referenceInterval())
+	defer referenceTicker.Stop()
+
+	// gather metrics
+	for {
+		m.beat()
+
+		select {
+		case <-referenceTicker.C:
+			m.gatherLock.Lock()
+			m.referenceCycle()
+			m.publishScore()
+			m.gatherLock.Unlock()
+
+		case <-time.After(m.config.RPCInterval):
+
+			elapsed := time.Since(lastIteration)
+			lastIteration = time.Now()
+
+			cycleCtx, cancel := m.cycleContext(ctx)
+			m.gatherLock.Lock()
+			err := m.rpcCycle(cycleCtx, elapsed)
+			m.publishScore()
+			m.gatherLock.Unlock()
+			cancel()
+
+			if err != nil {
+				return err
 			}
 		case <-ctx.Done():
-			m.logger.Println("Monitor shutting down")
+			return nil
+		}
+	}
+}
+
+// cycleContext returns the context of a gather cycle. With the watchdog
+// on, a cycle ends before the watchdog trips so a hung call does not
+// hold the loop. The heartbeats are an interval plus a cycle apart, half
+// an interval is left as margin.
+func (m *Monitor) cycleContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.config.WatchdogIntervals <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.watchdogTimeout()-m.config.RPCInterval-m.config.RPCInterval/2)
+}
+
+// referenceCycle compares the block number of the node with the
+// reference one
+func (m *Monitor) referenceCycle() {
+	// needs the block number of the node
+	if !m.connected || m.lastBlockNumber == nil {
+		return
+	}
+
+	previousState := m.synced
+	if !m.enabled("blocksbehind") {
+		// only the stalled head and the min height are left to decide
+		m.synced = !m.stalled() && m.reachedMinBlock(m.lastBlockNumber)
+	} else if err := m.gatherReference(m.lastBlockNumber); err != nil {
+		m.logger.Printf("Export errors: %v", err)
+	}
+	m.syncTransition(previousState)
+}
+
+// rpcCycle gathers the metrics of the node, or connects to it. Returns
+// the error the monitor can not recover from, if any.
+func (m *Monitor) rpcCycle(ctx context.Context, elapsed time.Duration) error {
+	// the calls of the cycle are cancelled with the loop
+	if m.ethClient != nil {
+		m.ethClient.SetContext(ctx)
+	}
+
+	if !m.connected {
+		metrics.IncrCounterWithLabels([]string{"connection", "downtimeSeconds"}, float32(elapsed.Seconds()), m.baseLabels)
+	}
+
+	previousConnected := m.connected
+
+	if m.connected {
+		previousState := m.synced
+
+		// RPC calls
+		err := m.gatherMetrics()
+		m.recordCycle(err != nil)
+		if err != nil {
+			m.logger.Printf("Export errors: %v", err)
+
+			if strings.Contains(err.Error(), "connection refused") { // TODO. Add fallback strategy
+				m.logger.Printf("Node may be down")
+				m.connected = false
+			}
+		}
+
+		m.syncTransition(previousState)
+
+	} else {
+
+		// setup APIS
+		if err := m.setupApis(ctx); err != nil {
+			if _, ok := err.(*unknownChainError); ok && m.config.StrictChain {
+				return err
+			}
+
+			m.logger.Printf("Failed to connect to node: %v", err)
+			m.connectFailed = true
+		} else {
+			m.logger.Printf("Chain connected. Gathering metrics...")
+			m.connected = true
+			m.connectFailed = false
+		}
+	}
+
+	m.connectionTransition(previousConnected)
+
+	warmingUp := 0
+	if m.warmingUp() {
+		warmingUp = 1
+	}
+	metrics.SetGaugeWithLabels([]string{"startup", "warmingUp"}, float32(warmingUp), m.baseLabels)
+
+	// A float32 holds the start time to about two minutes, the
+	// uptime is exact
+	metrics.SetGaugeWithLabels([]string{"process", "uptimeSeconds"}, float32(time.Since(m.startTime).Seconds()), m.baseLabels)
+	metrics.SetGaugeWithLabels([]string{"process", "startTime"}, float32(m.startTime.Unix()), m.baseLabels)
+
+	if m.remoteWriter != nil {
+		if err := m.remoteWriter.Push(); err != nil {
+			m.logger.Printf("Failed to push metrics to remote write: %v", err)
+		}
+	}
+
+	connected := 0
+	if m.connected {
+		connected = 1
+	}
+	metrics.SetGaugeWithLabels([]string{"connected"}, float32(connected), m.baseLabels)
+
+	return nil
+}
+
+// Actions of the watchdog
+const (
+	watchdogExit    = "exit"
+	watchdogRestart = "restart"
+)
+
+// Blocks and reward percentiles of the suggested gas prices
+const feeHistoryBlocks = 20
+
+var suggestedPercentiles = []float64{25, 50, 75}
+
+// optionalMetrics are the groups of calls that can be disabled with
+// DisabledMetrics
+var optionalMetrics = []string{
+	"netPeers",
+	"nodeInfo",
+	"block",
+	"storage",
+	"events",
+	"canary",
+	"endpoints",
+	"consensus",
+	"archive",
+	"txpool",
+	"blocksbehind",
+	"feeHistory",
+	"calls",
+}
+
+// enabled returns false if the group of calls is disabled
+func (m *Monitor) enabled(name string) bool {
+	for _, disabled := range m.config.DisabledMetrics {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
+
+// syncTransition counts the flips of the synced state
+func (m *Monitor) syncTransition(previousState bool) {
+	if previousState == m.synced {
+		return
+	}
+
+	fmt.Printf("State changed. Is Synced?: %v\n", m.synced)
+	metrics.IncrCounterWithLabels([]string{"sync", "transitions"}, 1, m.baseLabels)
+
+	event := "unsynced"
+	if m.synced {
+		event = "synced"
+	}
+	m.notify(event)
+}
+
+func (m *Monitor) connectionTransition(previousState bool) {
+	if previousState == m.connected {
+		return
+	}
+
+	event := "disconnected"
+	if m.connected {
+		event = "connected"
+
+		// the first connection is not a reconnect
+		if !m.disconnectedAt.IsZero() {
+			outage := time.Since(m.disconnectedAt)
+			m.logger.Printf("Reconnected after %s", outage.Round(time.Second))
+			metrics.AddSampleWithLabels([]string{"reconnect", "duration"}, float32(outage.Seconds()), m.baseLabels)
+			m.disconnectedAt = time.Time{}
 		}
+	} else {
+		m.disconnectedAt = time.Now()
+	}
+	m.notify(event)
+}
+
+// notify posts the state change to the webhook, if configured
+func (m *Monitor) notify(event string) {
+	if m.webhook == nil {
+		return
+	}
+
+	var block int64
+	if m.lastBlockNumber != nil {
+		block = m.lastBlockNumber.Int64()
 	}
+
+	m.webhook.Post(&webhookEvent{
+		Node:      m.nodeLabel(),
+		Chain:     m.chain,
+		Event:     event,
+		Synced:    m.synced,
+		Connected: m.connected,
+		Block:     block,
+		Timestamp: time.Now().Unix(),
+	})
 }
 
 func (m *Monitor) gatherMetrics() error {
 	var errors error
 
+	// Circuit breaker. Reported before the calls so it reflects the
+	// state the calls of this cycle run with
+
+	circuitOpen := 0
+	if m.ethClient.CircuitOpen() {
+		circuitOpen = 1
+	}
+	metrics.SetGaugeWithLabels([]string{"rpc", "circuitOpen"}, float32(circuitOpen), m.baseLabels)
+
+	// Chain id. Checked every cycle since a load balancer may route
+	// the endpoint to another network at any time
+
+	if m.config.ExpectedChainID != 0 {
+		if err := m.checkChainID(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Peers, block number, syncing and gas price. Batched in a single
+	// request or done in parallel if enabled
+
+	var cycle *CycleResult
+	switch {
+	case m.config.BatchRPC:
+		cycle = m.ethClient.Batch()
+	case m.config.GatherConcurrency > 1:
+		cycle = m.ethClient.Concurrent(m.config.GatherConcurrency)
+	default:
+		cycle = m.ethClient.Sequential()
+	}
+
+	if cycle.BatchSize != 0 {
+		metrics.SetGaugeWithLabels([]string{"rpc", "batchSize"}, float32(cycle.BatchSize), m.baseLabels)
+		metrics.AddSampleWithLabels([]string{"rpc", "batchLatency"}, float32(cycle.BatchLatency.Seconds()), m.baseLabels)
+	}
+
 	// Peers
 
-	peers, err := m.ethClient.PeerCount()
-	if err != nil {
+	if err := cycle.Errors["net_peerCount"]; err != nil {
 		errors = multierror.Append(errors, err)
 	} else {
-		metrics.SetGaugeWithLabels([]string{"peers"}, float32(peers), m.baseLabels)
+		metrics.SetGaugeWithLabels([]string{"peers"}, float32(cycle.PeerCount), m.baseLabels)
+
+		if m.peersSeen {
+			churn := cycle.PeerCount - m.peers
+			if churn < 0 {
+				churn = -churn
+			}
+			metrics.SetGaugeWithLabels([]string{"peers", "churn"}, float32(churn), m.baseLabels)
+		}
+
+		m.peers = cycle.PeerCount
+		m.peersSeen = true
 	}
 
-	// BlockNumber
+	// Peers direction and diversity
 
-	blockNumber, err := m.ethClient.BlockNumber()
-	if err != nil {
+	if m.enabled("netPeers") {
+		if err := m.gatherNetPeers(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Peer slots. Pinned at 1 the node rejects new inbound peers
+
+	maxPeers := m.config.MaxPeers
+	if maxPeers == 0 {
+		maxPeers = m.maxPeers
+	}
+	if maxPeers > 0 && cycle.Errors["net_peerCount"] == nil {
+		metrics.SetGaugeWithLabels([]string{"peers", "utilization"}, float32(cycle.PeerCount)/float32(maxPeers), m.baseLabels)
+	}
+
+	// NodeInfo. Only available if the admin api is enabled
+
+	if m.enabled("nodeInfo") && m.supports("admin_nodeInfo") {
+		if nodeInfo, err := m.ethClient.NodeInfo(); err == nil {
+			listening := 0
+			if nodeInfo.Ports.Listener != 0 {
+				listening = 1
+			}
+			metrics.SetGaugeWithLabels([]string{"node", "listening"}, float32(listening), m.baseLabels)
+
+			metrics.SetGaugeWithLabels([]string{"node", "info"}, 1, m.labels(
+				metrics.Label{Name: "enode", Value: nodeInfo.Enode},
+				metrics.Label{Name: "listener_port", Value: strconv.Itoa(nodeInfo.Ports.Listener)},
+				metrics.Label{Name: "discovery_port", Value: strconv.Itoa(nodeInfo.Ports.Discovery)},
+			))
+		}
+	}
+
+	// BlockNumber. The metric and the block follow PrimaryBlockTag, the
+	// sync state the latest block
+
+	blockNumber := cycle.BlockNumber
+	var primaryNumber *big.Int
+	if err := cycle.Errors["eth_blockNumber"]; err != nil {
+		errors = multierror.Append(errors, err)
+	} else if primaryNumber, err = m.primaryBlockNumber(blockNumber); err != nil {
+		errors = multierror.Append(errors, err)
+	} else {
+		metrics.SetGaugeWithLabels([]string{"blockNumber"}, float32(primaryNumber.Int64()), m.baseLabels)
+	}
+
+	// Syncing
+
+	if err := cycle.Errors["eth_syncing"]; err != nil {
 		errors = multierror.Append(errors, err)
 	} else {
-		metrics.SetGaugeWithLabels([]string{"blockNumber"}, float32(blockNumber.Int64()), m.baseLabels)
+		syncing := 0
+		if cycle.Syncing != nil {
+			syncing = 1
+		}
+		metrics.SetGaugeWithLabels([]string{"syncing"}, float32(syncing), m.baseLabels)
+
+		// before the eta, which replaces the previous current block
+		m.gatherSyncStalled(cycle.Syncing)
+		m.gatherSyncETA(cycle.Syncing)
+
+		// Only while syncing, a growing queue means the import is the
+		// bottleneck and not the network
+		if cycle.Syncing != nil {
+			if err := m.gatherSyncQueue(); err != nil {
+				errors = multierror.Append(errors, err)
+			}
+		}
 	}
 
 	// Block
 
-	block, err := m.ethClient.BlockByNumber(blockNumber)
+	// Full transactions are only fetched every FullBlockSampleRate blocks
+	fullTxs := false
+	if m.config.FullBlockSampleRate > 0 && primaryNumber != nil {
+		rate := big.NewInt(int64(m.config.FullBlockSampleRate))
+		if m.lastSampledBlock == nil || Sub(primaryNumber, m.lastSampledBlock).Cmp(rate) >= 0 {
+			fullTxs = true
+		}
+	}
+
+	var block *Block
+	var err error
+	if primaryNumber != nil && m.enabled("block") {
+		block, err = m.ethClient.BlockByNumber(primaryNumber, fullTxs)
+	}
 	if err != nil {
 		errors = multierror.Append(errors, err)
-	} else {
+	} else if block != nil {
+		if summary := block.TxSummary; summary != nil {
+			m.lastSampledBlock = primaryNumber
+
+			valueEther, _ := new(big.Float).Quo(new(big.Float).SetInt(summary.Value), big.NewFloat(1e18)).Float32()
+			metrics.SetGaugeWithLabels([]string{"block", "sample", "avgGasPrice"}, weiToGwei(summary.AvgGasPrice), m.baseLabels)
+			metrics.SetGaugeWithLabels([]string{"block", "sample", "valueTransferred"}, valueEther, m.baseLabels)
+			metrics.SetGaugeWithLabels([]string{"block", "sample", "contractCreations"}, float32(summary.ContractCreations), m.baseLabels)
+		}
+
+		m.gatherReorg(block)
+		if err := m.gatherReorgedOut(block); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+
+		if m.lastBlock == nil || m.lastBlock.Number.Cmp(block.Number) != 0 {
+			m.gatherFullness(block)
+			m.gatherMiners(block)
+		}
+
 		if m.lastBlock != nil {
 			blockTime := block.Timestamp.Sub(*m.lastBlock.Timestamp)
 			metrics.SetGaugeWithLabels([]string{"blocktime"}, float32(blockTime.Seconds()), m.baseLabels)
+
+			// the heads skip blocks when the interval is longer than
+			// the block time
+			blocks := new(big.Int).Sub(block.Number, m.lastBlock.Number).Int64()
+			if blockTime > 0 && blocks > 0 {
+				m.gatherTPS(block.Transactions, blockTime, blocks)
+			}
 		}
 		m.lastBlock = block
+
+		blockAge := time.Since(*block.Timestamp)
+		metrics.SetGaugeWithLabels([]string{"block", "age"}, float32(blockAge.Seconds()), m.baseLabels)
+
+		// A block from the future is only possible if the local clock is
+		// behind. The age is a lower bound of the skew
+		var skew time.Duration
+		if blockAge < 0 {
+			skew = -blockAge
+			m.logger.Printf("[WARN] Block %s timestamp is %s in the future, the local clock is skewed", block.Number, skew)
+		}
+		metrics.SetGaugeWithLabels([]string{"clock", "skewSeconds"}, float32(skew.Seconds()), m.baseLabels)
+
+		// Delay of a new head at the cycle it is first seen. Skipped on the
+		// first cycle since the head may have been produced long before.
+		// Always from the latest head, the block may be the safe or
+		// finalized one
+		if m.lastBlockNumber != nil && m.lastBlockNumber.Cmp(blockNumber) != 0 {
+			if delay, err := m.propagationDelay(block, blockNumber); err != nil {
+				errors = multierror.Append(errors, err)
+			} else {
+				metrics.SetGaugeWithLabels([]string{"block", "propagationDelay"}, float32(delay.Seconds()), m.baseLabels)
+			}
+		}
+
+		// Zero means the next block is overdue
+		nextExpectedIn := m.expectedBlockTime - blockAge
+		if nextExpectedIn < 0 {
+			nextExpectedIn = 0
+		}
+		metrics.SetGaugeWithLabels([]string{"block", "nextExpectedIn"}, float32(nextExpectedIn.Seconds()), m.baseLabels)
 	}
 
-	// Etherscan
+	// Pending gap. Disabled after the first failure since the node does
+	// not support the pending tag
 
-	if blockNumber != nil {
-		realBlockNumber, err := m.etherscan.BlockNumber()
+	if m.config.PendingGap && !m.pendingUnsupported && blockNumber != nil {
+		pending, err := m.ethClient.PendingBlockNumber()
 		if err != nil {
-			errors = multierror.Append(errors, err)
+			m.logger.Printf("[WARN] Pending block not supported, disabling block.pendingGap: %v", err)
+			m.pendingUnsupported = true
 		} else {
-			blocksbehind := Sub(realBlockNumber, blockNumber)
-			metrics.SetGaugeWithLabels([]string{"blocksbehind"}, float32(blocksbehind.Int64()), m.baseLabels)
+			gap := Sub(pending, blockNumber)
+			metrics.SetGaugeWithLabels([]string{"block", "pendingGap"}, float32(gap.Int64()), m.baseLabels)
+		}
+	}
 
-			blocksDiff := int(Abs(blocksbehind).Int64())
-			if blocksDiff <= m.config.SyncThreshold {
-				m.synced = true
-			} else {
-				m.synced = false
+	// Storage
+
+	if m.enabled("storage") {
+		for _, target := range m.config.WatchStorage {
+			value, err := m.ethClient.StorageAt(target.Address, target.Slot)
+			if err != nil {
+				errors = multierror.Append(errors, fmt.Errorf("failed to get storage %s at %s: %v", target.Slot, target.Address, err))
+				continue
 			}
 
+			valueFloat, _ := new(big.Float).SetInt(value).Float32()
+			metrics.SetGaugeWithLabels([]string{target.Metric}, valueFloat, m.labels(
+				metrics.Label{Name: "address", Value: target.Address},
+				metrics.Label{Name: "slot", Value: target.Slot},
+			))
 		}
 	}
 
-	return errors
+	// Contract calls
+
+	if m.enabled("calls") {
+		if err := m.gatherCalls(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Events
+
+	if blockNumber != nil && m.enabled("events") {
+		if err := m.gatherEvents(blockNumber); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// GasPrice. Exported in gwei, the samples let prometheus compute
+	// the percentiles over the scrape window
+
+	if err := cycle.Errors["eth_gasPrice"]; err != nil {
+		errors = multierror.Append(errors, err)
+	} else {
+		gwei := weiToGwei(cycle.GasPrice)
+		metrics.AddSampleWithLabels([]string{"gasPrice"}, gwei, m.baseLabels)
+		metrics.SetGaugeWithLabels([]string{"gasPrice", "last"}, gwei, m.baseLabels)
+
+		m.gatherGasTrend(gwei)
+
+		for _, gasCap := range m.config.GasPriceCaps {
+			overCap := 0
+			if gwei > float32(gasCap) {
+				overCap = 1
+			}
+			metrics.SetGaugeWithLabels([]string{"gas", "overCap"}, float32(overCap), m.labels(
+				metrics.Label{Name: "cap", Value: strconv.Itoa(gasCap)},
+			))
+		}
+	}
+
+	// Suggested gas prices from the fee history. Only on clients
+	// implementing eth_feeHistory
+
+	if m.enabled("feeHistory") && m.supports("eth_feeHistory") {
+		if err := m.gatherSuggestedGasPrices(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Canary
+
+	if m.enabled("canary") {
+		if err := m.gatherCanary(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Peer endpoints
+
+	if m.enabled("endpoints") {
+		if err := m.gatherPeerEndpoints(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Consensus
+
+	if m.beaconClient != nil && m.enabled("consensus") {
+		head, err := m.beaconClient.Head()
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to get beacon head: %v", err))
+		} else {
+			metrics.SetGaugeWithLabels([]string{"consensus", "headSlot"}, float32(head.Slot), m.baseLabels)
+
+			// Positive when the execution client lags the consensus client
+			if head.ExecutionBlock != nil && blockNumber != nil {
+				gap := Sub(head.ExecutionBlock, blockNumber)
+				metrics.SetGaugeWithLabels([]string{"consensus", "executionGap"}, float32(gap.Int64()), m.baseLabels)
+			}
+		}
+	}
+
+	// Archive. Probed once since it does not change at runtime
+
+	if m.archive == nil && m.enabled("archive") {
+		archive, err := m.ethClient.IsArchive()
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to probe archive mode: %v", err))
+		} else {
+			m.archive = &archive
+		}
+	}
+
+	if m.archive != nil {
+		archive := 0
+		if *m.archive {
+			archive = 1
+		}
+		metrics.SetGaugeWithLabels([]string{"node", "archive"}, float32(archive), m.baseLabels)
+	}
+
+	// Database size
+
+	if err := m.gatherDBSize(); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	// TxPool
+
+	if m.enabled("txpool") {
+		if err := m.gatherTxPool(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+
+		if err := m.gatherTxPoolStatus(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+
+		if err := m.gatherAccountPending(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	// Stalled head. Catches a frozen node even if etherscan lags too
+
+	if blockNumber != nil {
+		if m.lastBlockNumber != nil && m.lastBlockNumber.Cmp(blockNumber) == 0 {
+			m.stalledCycles++
+		} else {
+			m.stalledCycles = 0
+		}
+
+		// Blocks between the heads of two cycles that were never seen. If
+		// always high the interval is too long to sample every block time
+		if m.lastBlockNumber != nil {
+			skipped := Sub(Sub(blockNumber, m.lastBlockNumber), big.NewInt(1)).Int64()
+			if skipped < 0 {
+				skipped = 0
+			}
+			metrics.SetGaugeWithLabels([]string{"block", "numbersSkipped"}, float32(skipped), m.baseLabels)
+		}
+		m.lastBlockNumber = blockNumber
+
+		metrics.SetGaugeWithLabels([]string{"block", "stalledCycles"}, float32(m.stalledCycles), m.baseLabels)
+
+		if m.stalled() {
+			m.logger.Printf("[WARN] Block number %s unchanged for %d cycles", blockNumber, m.stalledCycles)
+			m.synced = false
+		}
+	}
+
+	// Calls made to the endpoint during the cycle
+
+	for method, n := range m.ethClient.CallCounts() {
+		metrics.SetGaugeWithLabels([]string{"rpc", "callsPerCycle"}, float32(n), m.labels(
+			metrics.Label{Name: "method", Value: method},
+		))
+	}
+
+	return errors
+}
+
+// gatherTxPool exports the age of the oldest pending transaction sent by
+// the watched addresses. The age is measured since the transaction was
+// first seen in the pool.
+func (m *Monitor) gatherTxPool() error {
+	if len(m.config.WatchAddresses) == 0 || !m.supports("parity_pendingTransactions") {
+		return nil
+	}
+	if time.Since(m.lastTxPoolCheck) < m.config.TxPoolInterval {
+		return nil
+	}
+	m.lastTxPoolCheck = time.Now()
+
+	txs, err := m.ethClient.PendingTransactions()
+	if err != nil {
+		return err
+	}
+
+	watched := map[string]bool{}
+	for _, addr := range m.config.WatchAddresses {
+		watched[strings.ToLower(addr)] = true
+	}
+
+	now := time.Now()
+	pending := map[string]time.Time{}
+
+	for _, tx := range txs {
+		if !watched[strings.ToLower(tx.From)] {
+			continue
+		}
+
+		seen, ok := m.pendingSeen[tx.Hash]
+		if !ok {
+			seen = now
+		}
+		pending[tx.Hash] = seen
+	}
+
+	// drop the transactions that are not pending anymore
+	m.pendingSeen = pending
+
+	var oldest time.Duration
+	for _, seen := range pending {
+		if age := now.Sub(seen); age > oldest {
+			oldest = age
+		}
+	}
+
+	metrics.SetGaugeWithLabels([]string{"txpool", "oldestPendingAge"}, float32(oldest.Seconds()), m.baseLabels)
+	return nil
+}
+
+// primaryBlockNumber returns the number of the PrimaryBlockTag block. If
+// it is not the latest one both numbers are exported by tag.
+func (m *Monitor) primaryBlockNumber(latest *big.Int) (*big.Int, error) {
+	tag := m.config.PrimaryBlockTag
+	if tag == "" || tag == "latest" {
+		return latest, nil
+	}
+
+	metrics.SetGaugeWithLabels([]string{"blockNumber", "byTag"}, float32(latest.Int64()), m.labels(
+		metrics.Label{Name: "tag", Value: "latest"},
+	))
+
+	number, err := m.ethClient.TaggedBlockNumber(tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the %s block number: %v", tag, err)
+	}
+
+	metrics.SetGaugeWithLabels([]string{"blockNumber", "byTag"}, float32(number.Int64()), m.labels(
+		metrics.Label{Name: "tag", Value: tag},
+	))
+
+	return number, nil
+}
+
+// gatherReorg exports the depth of the reorg if the head moved to a lower
+// or equal block number with a different hash than the last head
+func (m *Monitor) gatherReorg(block *Block) {
+	last := m.lastBlock
+	if last == nil || last.Number == nil || last.Hash == "" || block.Hash == "" {
+		return
+	}
+	if block.Number.Cmp(last.Number) > 0 || block.Hash == last.Hash {
+		return
+	}
+
+	depth := Sub(last.Number, block.Number).Int64() + 1
+
+	m.logger.Printf("[WARN] Reorg of depth %d, head moved from %s (%s) to %s (%s)", depth, last.Number, last.Hash, block.Number, block.Hash)
+	metrics.AddSampleWithLabels([]string{"reorg", "depth"}, float32(depth), m.baseLabels)
+}
+
+// gatherReorgedOut exports whether the previous head is still on the
+// canonical chain. Once the head moves on, the canonical block at the
+// height of the previous head is fetched to compare the hashes.
+func (m *Monitor) gatherReorgedOut(block *Block) error {
+	last := m.lastBlock
+	if last == nil || last.Number == nil || last.Hash == "" || block.Hash == "" {
+		return nil
+	}
+
+	var canonicalHash string
+	switch block.Number.Cmp(last.Number) {
+	case 0:
+		canonicalHash = block.Hash
+	case 1:
+		canonical, err := m.ethClient.BlockByNumber(last.Number, false)
+		if err != nil {
+			return err
+		}
+		canonicalHash = canonical.Hash
+	default:
+		// the head went back, the previous one is not the head anymore
+		// and its height may not be reached again yet
+	}
+
+	reorgedOut := 0
+	if canonicalHash != last.Hash {
+		reorgedOut = 1
+		m.logger.Printf("[WARN] Block %s (%s) is not on the canonical chain anymore", last.Number, last.Hash)
+	}
+	metrics.SetGaugeWithLabels([]string{"block", "reorgedOut"}, float32(reorgedOut), m.baseLabels)
+
+	return nil
+}
+
+// gatherFullness exports the gas used ratio of a new head, its average
+// over the last FullnessWindow heads, the gas used per second and the
+// uncle rate over them. Heads skipped between cycles are missing from the
+// throughput.
+func (m *Monitor) gatherFullness(block *Block) {
+	if block.GasUsed == nil || block.GasLimit == nil || block.GasLimit.Sign() == 0 {
+		return
+	}
+
+	ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(block.GasUsed), new(big.Float).SetInt(block.GasLimit)).Float64()
+	metrics.SetGaugeWithLabels([]string{"block", "gasUsedRatio"}, float32(ratio), m.baseLabels)
+
+	if m.config.FullnessWindow <= 0 {
+		return
+	}
+
+	gasUsed, _ := new(big.Float).SetInt(block.GasUsed).Float64()
+	sample := fullnessSample{ratio, gasUsed, block.Uncles, *block.Timestamp}
+
+	var oldest fullnessSample
+	if len(m.fullness) < m.config.FullnessWindow {
+		m.fullness = append(m.fullness, sample)
+		oldest = m.fullness[0]
+	} else {
+		m.fullness[m.fullnessNext%len(m.fullness)] = sample
+		oldest = m.fullness[(m.fullnessNext+1)%len(m.fullness)]
+	}
+	m.fullnessNext++
+
+	var sum, gas float64
+	var uncles int
+	for _, head := range m.fullness {
+		sum += head.ratio
+		gas += head.gasUsed
+		uncles += head.uncles
+	}
+	metrics.SetGaugeWithLabels([]string{"block", "avgFullness"}, float32(sum/float64(len(m.fullness))), m.baseLabels)
+
+	// percentage of the produced blocks that ended up as uncles
+	uncleRate := 100 * float64(uncles) / float64(len(m.fullness)+uncles)
+	metrics.SetGaugeWithLabels([]string{"chain", "uncleRate"}, float32(uncleRate), m.baseLabels)
+
+	// the gas of the oldest block was used before the window starts
+	elapsed := sample.timestamp.Sub(oldest.timestamp)
+	if elapsed > 0 {
+		throughput := (gas - oldest.gasUsed) / elapsed.Seconds()
+		metrics.SetGaugeWithLabels([]string{"chain", "gasThroughput"}, float32(throughput), m.baseLabels)
+	}
+}
+
+// fullnessSample is a head of the fullness window
+type fullnessSample struct {
+	ratio     float64
+	gasUsed   float64
+	uncles    int
+	timestamp time.Time
+}
+
+// minerOther labels the blocks of the miners that are not known
+const minerOther = "other"
+
+// gatherMiners exports the blocks produced by every known miner in the
+// latest heads. Unknown miners are grouped to bound the cardinality.
+func (m *Monitor) gatherMiners(block *Block) {
+	if len(m.config.KnownMiners) == 0 || block.Miner == "" {
+		return
+	}
+
+	miner := minerOther
+	for _, known := range m.config.KnownMiners {
+		if strings.EqualFold(known, block.Miner) {
+			miner = known
+			break
+		}
+	}
+
+	m.miners = append(m.miners, miner)
+	if m.config.MinerWindow > 0 && len(m.miners) > m.config.MinerWindow {
+		m.miners = m.miners[len(m.miners)-m.config.MinerWindow:]
+	}
+
+	counts := map[string]int{minerOther: 0}
+	for _, known := range m.config.KnownMiners {
+		counts[known] = 0
+	}
+	for _, miner := range m.miners {
+		counts[miner]++
+	}
+
+	for miner, count := range counts {
+		metrics.SetGaugeWithLabels([]string{"block", "minerCount"}, float32(count), m.labels(
+			metrics.Label{Name: "miner", Value: miner},
+		))
+	}
+}
+
+// tpsWindowSize is the number of heads the tps is smoothed over
+const tpsWindowSize = 10
+
+// tpsSample is a head and the time since the previous one, which is
+// blocks apart
+type tpsSample struct {
+	transactions int
+	blockTime    time.Duration
+	blocks       int64
+}
+
+// gatherTPS exports the transactions per second over the latest heads.
+// The window smooths the drops caused by empty blocks.
+func (m *Monitor) gatherTPS(transactions int, blockTime time.Duration, blocks int64) {
+	m.tpsWindow = append(m.tpsWindow, tpsSample{transactions, blockTime, blocks})
+	if len(m.tpsWindow) > tpsWindowSize {
+		m.tpsWindow = m.tpsWindow[1:]
+	}
+
+	metrics.SetGaugeWithLabels([]string{"chain", "tps"}, float32(m.tps()), m.baseLabels)
+}
+
+// tps returns the transactions per second of the window. Only the
+// transactions of the heads are known, so each one counts over the time
+// of a single block, not over the time since the previous head.
+func (m *Monitor) tps() float64 {
+	var txs int
+	var elapsed time.Duration
+	for _, sample := range m.tpsWindow {
+		txs += sample.transactions
+		elapsed += sample.blockTime / time.Duration(sample.blocks)
+	}
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(txs) / elapsed.Seconds()
+}
+
+// gatherReference compares the block number of the node against the
+// reference explorers and updates the synced state. It runs on its own
+// interval since the explorers are rate limited.
+func (m *Monitor) gatherReference(blockNumber *big.Int) error {
+	// a hung explorer must not hold the gather loop past the next lookup
+	ctx, cancel := context.WithTimeout(context.Background(), m.referenceInterval())
+	defer cancel()
+
+	realBlockNumber, active, err := referenceBlockNumber(ctx, m.explorers)
+	if err != nil {
+		return err
+	}
+
+	for _, explorer := range m.explorers {
+		isActive := 0
+		if explorer.name == active {
+			isActive = 1
+		}
+		metrics.SetGaugeWithLabels([]string{"reference", "active"}, float32(isActive), m.labels(
+			metrics.Label{Name: "explorer", Value: explorer.name},
+		))
+	}
+
+	blocksbehind := Sub(realBlockNumber, blockNumber)
+	metrics.SetGaugeWithLabels([]string{"blocksbehind"}, float32(blocksbehind.Int64()), m.baseLabels)
+
+	behindCount := blocksbehind.Int64()
+	m.blocksBehind = &behindCount
+
+	// Split by direction. Ahead usually means etherscan is stale
+	var ahead, behind int64
+	if blocksbehind.Sign() > 0 {
+		behind = blocksbehind.Int64()
+	} else {
+		ahead = -blocksbehind.Int64()
+	}
+	metrics.SetGaugeWithLabels([]string{"node", "behindReference"}, float32(behind), m.baseLabels)
+	metrics.SetGaugeWithLabels([]string{"node", "aheadOfReference"}, float32(ahead), m.baseLabels)
+
+	// Only the lag counts, on fast chains the node is legitimately ahead
+	// of the explorer indexing. A stalled head or one under the min
+	// height is never synced, even if the explorer lags too
+	if behind <= m.syncThreshold() && !m.stalled() && m.reachedMinBlock(blockNumber) {
+		m.synced = true
+	} else {
+		m.synced = false
+	}
+
+	return nil
+}
+
+// referenceInterval returns the interval between reference lookups
+func (m *Monitor) referenceInterval() time.Duration {
+	if m.config.ReferenceInterval <= 0 {
+		return m.config.RPCInterval
+	}
+	return m.config.ReferenceInterval
+}
+
+// defaultSyncThreshold is the sync threshold in blocks if none is set
+const defaultSyncThreshold = 5
+
+// syncThreshold returns the blocks behind the reference the node is still
+// synced. The static threshold wins over the one in seconds, which uses
+// the observed block time once there are heads to compute it.
+func (m *Monitor) syncThreshold() int64 {
+	if m.config.SyncThreshold != 0 {
+		return int64(m.config.SyncThreshold)
+	}
+	if m.config.SyncThresholdSeconds == 0 {
+		return defaultSyncThreshold
+	}
+
+	blockTime := m.expectedBlockTime
+	if len(m.tpsWindow) != 0 {
+		var elapsed time.Duration
+		var blocks int64
+		for _, sample := range m.tpsWindow {
+			elapsed += sample.blockTime
+			blocks += sample.blocks
+		}
+		blockTime = elapsed / time.Duration(blocks)
+	}
+	if blockTime <= 0 {
+		return defaultSyncThreshold
+	}
+
+	threshold := int64(math.Ceil(float64(m.config.SyncThresholdSeconds) / blockTime.Seconds()))
+	if threshold < 1 {
+		threshold = 1
+	}
+	return threshold
+}
+
+// reachedMinBlock returns false if the block number is under
+// MinBlockNumber, which is exported for visibility
+func (m *Monitor) reachedMinBlock(blockNumber *big.Int) bool {
+	if m.config.MinBlockNumber == 0 {
+		return true
+	}
+
+	metrics.SetGaugeWithLabels([]string{"sync", "minBlockNumber"}, float32(m.config.MinBlockNumber), m.baseLabels)
+	return blockNumber.Cmp(big.NewInt(m.config.MinBlockNumber)) >= 0
+}
+
+// stalled returns true if the block number has not changed for more
+// cycles than allowed
+func (m *Monitor) stalled() bool {
+	return m.config.StalledCyclesThreshold != 0 && m.stalledCycles > m.config.StalledCyclesThreshold
+}
+
+// Decoders of the result of a contract call
+const (
+	decodeUint = "uint"
+	decodeInt  = "int"
+	decodeBool = "bool"
+)
+
+// gatherCalls exports the decoded result of every contract call. The
+// failed calls, usually reverts, are counted.
+func (m *Monitor) gatherCalls() error {
+	var errors error
+
+	for _, call := range m.config.WatchCalls {
+		word, err := m.ethClient.Call(call.Address, call.CallData)
+		if err != nil {
+			metrics.IncrCounterWithLabels([]string{"contract", "callErrors"}, 1, m.labels(
+				metrics.Label{Name: "address", Value: call.Address},
+				metrics.Label{Name: "metric", Value: call.Metric},
+			))
+			errors = multierror.Append(errors, fmt.Errorf("failed to call %s for %s: %v", call.Address, call.Metric, err))
+			continue
+		}
+
+		value := decodeWord(word, call.ResultDecoder)
+		valueFloat, _ := new(big.Float).SetInt(value).Float32()
+		metrics.SetGaugeWithLabels([]string{call.Metric}, valueFloat, m.labels(
+			metrics.Label{Name: "address", Value: call.Address},
+		))
+	}
+
+	return errors
+}
+
+// decodeWord decodes an abi encoded 32 bytes word
+func decodeWord(word *big.Int, decoder string) *big.Int {
+	switch decoder {
+	case decodeInt:
+		// two's complement
+		if word.Bit(255) == 1 {
+			return new(big.Int).Sub(word, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+	case decodeBool:
+		if word.Sign() != 0 {
+			return big.NewInt(1)
+		}
+		return big.NewInt(0)
+	}
+	return word
+}
+
+// gatherEvents counts the events of the watched contracts emitted since
+// the last scanned block. The first cycle only sets the starting point.
+func (m *Monitor) gatherEvents(blockNumber *big.Int) error {
+	var errors error
+
+	for indx, filter := range m.config.WatchEvents {
+		last, ok := m.lastScannedBlock[indx]
+		if !ok || last.Cmp(blockNumber) > 0 {
+			// first cycle or the head went backwards (i.e. new endpoint)
+			m.lastScannedBlock[indx] = blockNumber
+			continue
+		}
+		if last.Cmp(blockNumber) == 0 {
+			continue
+		}
+
+		from := big.NewInt(0).Add(last, big.NewInt(1))
+		to := blockNumber
+		if m.config.MaxLogsRange > 0 {
+			max := big.NewInt(0).Add(last, big.NewInt(int64(m.config.MaxLogsRange)))
+			if to.Cmp(max) > 0 {
+				to = max
+			}
+		}
+
+		count, err := m.ethClient.LogCount(filter.Address, filter.Topic0, from, to)
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to get the %s events of %s: %v", filter.Metric, filter.Address, err))
+			continue
+		}
+		m.lastScannedBlock[indx] = to
+
+		metrics.IncrCounterWithLabels([]string{"contract", "eventCount"}, float32(count), m.labels(
+			metrics.Label{Name: "address", Value: filter.Address},
+			metrics.Label{Name: "event", Value: filter.Metric},
+		))
+	}
+
+	return errors
+}
+
+// gatherCanary tracks the canary transaction in flight or sends a new one
+// once the interval expires. A single canary is in flight at a time so a
+// stuck one is never duplicated.
+func (m *Monitor) gatherCanary() error {
+	address := m.config.CanaryAddress
+	if address == "" {
+		return nil
+	}
+
+	if m.canaryHash != "" {
+		included, err := m.ethClient.TransactionIncluded(m.canaryHash)
+		if err != nil {
+			return fmt.Errorf("failed to get the canary receipt: %v", err)
+		}
+
+		if included {
+			if !m.canaryFailed {
+				metrics.SetGaugeWithLabels([]string{"canary", "inclusionSeconds"}, float32(time.Since(m.canarySentAt).Seconds()), m.baseLabels)
+				metrics.SetGaugeWithLabels([]string{"canary", "success"}, 1, m.baseLabels)
+			}
+			m.canaryHash = ""
+			return nil
+		}
+
+		if !m.canaryFailed && time.Since(m.canarySentAt) > m.config.CanaryTimeout {
+			m.logger.Printf("[WARN] Canary transaction %s not included after %s", m.canaryHash, m.config.CanaryTimeout)
+			metrics.SetGaugeWithLabels([]string{"canary", "success"}, 0, m.baseLabels)
+			m.canaryFailed = true
+		}
+
+		// replaced or dropped, the nonce was used by another transaction
+		latest, err := m.ethClient.TransactionCount(address, "latest")
+		if err != nil {
+			return err
+		}
+		if latest.Cmp(m.canaryNonce) > 0 {
+			m.canaryHash = ""
+		}
+		return nil
+	}
+
+	if time.Since(m.canarySentAt) < m.config.CanaryInterval {
+		return nil
+	}
+
+	latest, err := m.ethClient.TransactionCount(address, "latest")
+	if err != nil {
+		return err
+	}
+	pending, err := m.ethClient.TransactionCount(address, "pending")
+	if err != nil {
+		return err
+	}
+	if pending.Cmp(latest) != 0 {
+		return fmt.Errorf("canary account %s has %s pending transactions, not sending", address, Sub(pending, latest))
+	}
+
+	hash, err := m.ethClient.SendSelfTransfer(address, latest)
+	m.canarySentAt = time.Now()
+	if err != nil {
+		metrics.SetGaugeWithLabels([]string{"canary", "success"}, 0, m.baseLabels)
+		return fmt.Errorf("failed to send the canary transaction: %v", err)
+	}
+
+	m.canaryHash = hash
+	m.canaryNonce = latest
+	m.canaryFailed = false
+	return nil
+}
+
+// gasTrendAlpha is the weight of the latest change in the gas price trend
+const gasTrendAlpha = 0.2
+
+// gatherGasTrend exports the change of the gas price since the previous
+// cycle and its exponential moving average. Positive means rising.
+func (m *Monitor) gatherGasTrend(gwei float32) {
+	last := m.lastGasPrice
+	m.lastGasPrice = &gwei
+	if last == nil {
+		return
+	}
+
+	delta := float64(gwei - *last)
+	m.gasTrendEma = gasTrendAlpha*delta + (1-gasTrendAlpha)*m.gasTrendEma
+
+	metrics.SetGaugeWithLabels([]string{"gas", "priceTrend"}, float32(delta), m.baseLabels)
+	metrics.SetGaugeWithLabels([]string{"gas", "priceTrendEma"}, float32(m.gasTrendEma), m.baseLabels)
+}
+
+// gatherSuggestedGasPrices exports the suggested gas prices in gwei at
+// every suggested percentile
+func (m *Monitor) gatherSuggestedGasPrices() error {
+	prices, err := m.ethClient.SuggestedGasPrices(feeHistoryBlocks, suggestedPercentiles)
+	if err != nil {
+		return err
+	}
+
+	for indx, price := range prices {
+		name := fmt.Sprintf("p%d", int(suggestedPercentiles[indx]))
+		metrics.SetGaugeWithLabels([]string{"gas", "suggested", name}, weiToGwei(price), m.baseLabels)
+	}
+
+	return nil
+}
+
+// gatherAccountPending exports the pending transactions of every watched
+// address. The txpool dump is expensive, so it is done every
+// TxPoolInterval and only if enabled. Disabled after the first failure
+// in favour of the nonce gap, which misses the transactions queued
+// behind a nonce gap.
+func (m *Monitor) gatherAccountPending() error {
+	if !m.config.AccountPendingTxs || len(m.config.WatchAddresses) == 0 {
+		return nil
+	}
+	if time.Since(m.lastAccountPendingCheck) < m.config.TxPoolInterval {
+		return nil
+	}
+	m.lastAccountPendingCheck = time.Now()
+
+	var counts map[string]int
+	if !m.txPoolContentUnsupported {
+		var err error
+		if counts, err = m.ethClient.PendingTransactionsFrom(); err != nil {
+			m.logger.Printf("[WARN] Txpool content not supported, using the nonce gap: %v", err)
+			m.txPoolContentUnsupported = true
+		}
+	}
+
+	var errors error
+	for _, addr := range m.config.WatchAddresses {
+		count := counts[strings.ToLower(addr)]
+		if m.txPoolContentUnsupported {
+			latest, err := m.ethClient.TransactionCount(addr, "latest")
+			if err != nil {
+				errors = multierror.Append(errors, err)
+				continue
+			}
+			pending, err := m.ethClient.TransactionCount(addr, "pending")
+			if err != nil {
+				errors = multierror.Append(errors, err)
+				continue
+			}
+			count = int(Sub(pending, latest).Int64())
+		}
+
+		metrics.SetGaugeWithLabels([]string{"account", "pendingTxCount"}, float32(count), m.labels(
+			metrics.Label{Name: "address", Value: addr},
+		))
+	}
+
+	return errors
+}
+
+// gatherTxPoolStatus exports the pending and queued transactions and how
+// close the pending ones are to the limit of the pool
+func (m *Monitor) gatherTxPoolStatus() error {
+	if !m.supports("txpool_status") {
+		return nil
+	}
+
+	status, err := m.ethClient.TxPoolStatus()
+	if err != nil {
+		return err
+	}
+
+	metrics.SetGaugeWithLabels([]string{"txpool", "pending"}, float32(status.Pending), m.baseLabels)
+	metrics.SetGaugeWithLabels([]string{"txpool", "queued"}, float32(status.Queued), m.baseLabels)
+
+	max := m.config.TxPoolMaxPending
+	if max == 0 && m.supports("parity_transactionsLimit") {
+		if max, err = m.ethClient.TransactionsLimit(); err != nil {
+			return err
+		}
+	}
+	if max <= 0 {
+		return nil
+	}
+
+	metrics.SetGaugeWithLabels([]string{"txpool", "maxPending"}, float32(max), m.baseLabels)
+	metrics.SetGaugeWithLabels([]string{"txpool", "utilization"}, float32(status.Pending)/float32(max), m.baseLabels)
+	return nil
+}
+
+// propagationDelay returns the age of the latest head. The block of the
+// cycle is reused if it is the latest one.
+func (m *Monitor) propagationDelay(block *Block, latest *big.Int) (time.Duration, error) {
+	if block.Number.Cmp(latest) != 0 {
+		var err error
+		if block, err = m.ethClient.BlockByNumber(latest, false); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(*block.Timestamp), nil
+}
+
+// gatherPeerEndpoints exports the block number of every peer endpoint and
+// the spread between the highest and the lowest one
+func (m *Monitor) gatherPeerEndpoints() error {
+	if len(m.peerClients) == 0 {
+		return nil
+	}
+
+	var errors error
+	var min, max *big.Int
+
+	for indx, client := range m.peerClients {
+		endpoint := redactURL(m.config.PeerEndpoints[indx])
+
+		blockNumber, err := client.BlockNumber()
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to get block number of %s: %v", endpoint, err))
+			continue
+		}
+
+		metrics.SetGaugeWithLabels([]string{"endpoints", "blockNumber"}, float32(blockNumber.Int64()), m.labels(
+			metrics.Label{Name: "endpoint", Value: endpoint},
+		))
+
+		if min == nil || blockNumber.Cmp(min) < 0 {
+			min = blockNumber
+		}
+		if max == nil || blockNumber.Cmp(max) > 0 {
+			max = blockNumber
+		}
+	}
+
+	if min != nil {
+		spread := Sub(max, min)
+		metrics.SetGaugeWithLabels([]string{"endpoints", "maxHeightSpread"}, float32(spread.Int64()), m.baseLabels)
+	}
+
+	return errors
+}
+
+// gatherNetPeers exports the inbound and outbound peers. Nothing is
+// exported if the client does not expose the connection direction.
+func (m *Monitor) gatherNetPeers() error {
+	var peers []Peer
+	switch {
+	case m.supports("parity_netPeers"):
+		netPeers, err := m.ethClient.NetPeers()
+		if err != nil {
+			return err
+		}
+		m.maxPeers = netPeers.Max
+		peers = netPeers.Peers
+	case m.supports("admin_peers"):
+		adminPeers, err := m.ethClient.AdminPeers()
+		if err != nil {
+			return err
+		}
+		peers = adminPeers
+	default:
+		return nil
+	}
+
+	m.gatherDistinctPeers(peers)
+
+	var inbound, outbound int
+	for _, peer := range peers {
+		if peer.Network.Inbound == nil {
+			continue
+		}
+		if *peer.Network.Inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+
+	if inbound+outbound == 0 {
+		return nil
+	}
+
+	metrics.SetGaugeWithLabels([]string{"peers", "inbound"}, float32(inbound), m.baseLabels)
+	metrics.SetGaugeWithLabels([]string{"peers", "outbound"}, float32(outbound), m.baseLabels)
+	return nil
+}
+
+// gatherDistinctPeers exports the distinct peers seen within PeerWindow
+func (m *Monitor) gatherDistinctPeers(peers []Peer) {
+	now := time.Now()
+	for _, peer := range peers {
+		// not known until the handshake completes
+		if peer.ID == "" {
+			continue
+		}
+		m.seenPeers.Add(peer.ID, now)
+	}
+
+	for {
+		_, seen, ok := m.seenPeers.GetOldest()
+		if !ok || now.Sub(seen.(time.Time)) <= m.config.PeerWindow {
+			break
+		}
+		m.seenPeers.RemoveOldest()
+	}
+
+	metrics.SetGaugeWithLabels([]string{"peers", "distinctSeen"}, float32(m.seenPeers.Len()), m.baseLabels)
+}
+
+// gatherSyncQueue exports the blocks waiting to be imported. There is no
+// standard method for it, SyncQueueMethod must return the number of
+// blocks and the node must implement it.
+func (m *Monitor) gatherSyncQueue() error {
+	method := m.config.SyncQueueMethod
+	if method == "" || !m.supports(method) {
+		return nil
+	}
+
+	queued, err := m.ethClient.QueuedBlocks(method)
+	if err != nil {
+		return fmt.Errorf("failed to get the sync queue: %v", err)
+	}
+
+	metrics.SetGaugeWithLabels([]string{"sync", "queuedBlocks"}, float32(queued), m.baseLabels)
+	return nil
+}
+
+// gatherDBSize exports the size of the database of the node. There is no
+// standard method for it, DBSizeMethod must return the size in bytes and
+// the node must implement it.
+func (m *Monitor) gatherDBSize() error {
+	method := m.config.DBSizeMethod
+	if method == "" || !m.supports(method) {
+		return nil
+	}
+	if time.Since(m.lastDBSizeCheck) < m.config.DBSizeInterval {
+		return nil
+	}
+	m.lastDBSizeCheck = time.Now()
+
+	size, err := m.ethClient.DBSize(method)
+	if err != nil {
+		return fmt.Errorf("failed to get the database size: %v", err)
+	}
+
+	metrics.SetGaugeWithLabels([]string{"node", "dbSizeBytes"}, float32(size), m.baseLabels)
+	return nil
+}
+
+// gatherSyncStalled exports whether the node is syncing but its current
+// block has not moved for SyncStalledCycles cycles, a wedged sync rather
+// than a slow one.
+func (m *Monitor) gatherSyncStalled(sync *RpcSync) {
+	if sync == nil {
+		m.syncStalledCycles = 0
+	} else if m.lastSyncBlock != nil && sync.CurrentBlock != nil && sync.CurrentBlock.Cmp(m.lastSyncBlock) == 0 {
+		m.syncStalledCycles++
+	} else {
+		m.syncStalledCycles = 0
+	}
+
+	stalled := 0
+	if m.config.SyncStalledCycles != 0 && m.syncStalledCycles >= m.config.SyncStalledCycles {
+		stalled = 1
+		if m.syncStalledCycles == m.config.SyncStalledCycles {
+			m.logger.Printf("[WARN] Syncing with the current block at %s for %d cycles", sync.CurrentBlock, m.syncStalledCycles)
+		}
+	}
+	metrics.SetGaugeWithLabels([]string{"sync", "stalled"}, float32(stalled), m.baseLabels)
+}
+
+// gatherSyncETA exports the estimated seconds until the node is synced.
+// -1 if the node is not syncing or it is not catching up.
+func (m *Monitor) gatherSyncETA(sync *RpcSync) {
+	eta := float32(-1)
+
+	if sync == nil {
+		m.lastSyncBlock = nil
+	} else {
+		now := time.Now()
+
+		if m.lastSyncBlock != nil {
+			elapsed := now.Sub(m.lastSyncTime).Seconds()
+			processed := Sub(sync.CurrentBlock, m.lastSyncBlock).Int64()
+
+			if elapsed > 0 && processed > 0 {
+				rate := float64(processed) / elapsed
+				remaining := Sub(sync.HighestBlock, sync.CurrentBlock).Int64()
+				eta = float32(float64(remaining) / rate)
+			}
+		}
+
+		m.lastSyncBlock = sync.CurrentBlock
+		m.lastSyncTime = now
+	}
+
+	metrics.SetGaugeWithLabels([]string{"sync", "etaSeconds"}, eta, m.baseLabels)
 }
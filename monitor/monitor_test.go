@@ -0,0 +1,428 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lenientRegisterer ignores the collectors registered twice. Every
+// monitor of the tests has its own prometheus sink, which registers the
+// same runtime metrics in the default registry.
+type lenientRegisterer struct {
+	prometheus.Registerer
+}
+
+func (l lenientRegisterer) Register(c prometheus.Collector) error {
+	if err := l.Registerer.Register(c); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l lenientRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := l.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	prometheus.DefaultRegisterer = lenientRegisterer{prometheus.DefaultRegisterer}
+	os.Exit(m.Run())
+}
+
+// newHungNode returns a node that does not answer until the request is
+// cancelled, or for a second
+func newHungNode() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+}
+
+// newStubNode returns a node on the foundation chain whose head moves
+// on every eth_blockNumber call. Unknown methods return an rpc error.
+func newStubNode() *httptest.Server {
+	var head int64 = 1000
+
+	answer := func(req RPCRequest) map[string]interface{} {
+		res := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+
+		switch req.Method {
+		case "parity_chain":
+			res["result"] = "foundation"
+		case "web3_clientVersion":
+			res["result"] = "Geth/v1.10.0"
+		case "net_peerCount":
+			res["result"] = "0x5"
+		case "eth_syncing":
+			res["result"] = false
+		case "eth_gasPrice":
+			res["result"] = "0x3b9aca00"
+		case "eth_blockNumber":
+			res["result"] = fmt.Sprintf("0x%x", atomic.AddInt64(&head, 1))
+		case "eth_getBlockByNumber":
+			number := atomic.LoadInt64(&head)
+			res["result"] = map[string]interface{}{
+				"number":       fmt.Sprintf("0x%x", number),
+				"hash":         fmt.Sprintf("0x%064x", number),
+				"miner":        "0x0000000000000000000000000000000000000001",
+				"timestamp":    fmt.Sprintf("0x%x", time.Now().Unix()),
+				"gasLimit":     "0x1c9c380",
+				"gasUsed":      "0xe4e1c0",
+				"transactions": []interface{}{},
+				"uncles":       []interface{}{},
+			}
+		default:
+			res["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+		return res
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+
+		var batch []RPCRequest
+		if err := json.Unmarshal(data, &batch); err == nil {
+			results := []interface{}{}
+			for _, req := range batch {
+				results = append(results, answer(req))
+			}
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+
+		var req RPCRequest
+		json.Unmarshal(data, &req)
+		json.NewEncoder(w).Encode(answer(req))
+	}))
+}
+
+// testMonitors counts the monitors created by the tests. The prometheus
+// sinks share the default registry, every monitor needs its own node name
+var testMonitors int32
+
+// newTestMonitor returns a monitor of the endpoint with the watchdog on
+// and short intervals
+func newTestMonitor(t *testing.T, endpoint string) *Monitor {
+	m, err := NewMonitor(newTestConfig(endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newTestConfig returns the config of newTestMonitor
+func newTestConfig(endpoint string) *Config {
+	enabled := false
+
+	config := DefaultConfig()
+	config.Endpoint = endpoint
+	config.NodeName = fmt.Sprintf("test-%d", atomic.AddInt32(&testMonitors, 1))
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	config.LogOutput = ioutil.Discard
+	config.ConsulConfig.Enabled = &enabled
+	config.RPCInterval = 20 * time.Millisecond
+	config.WatchdogIntervals = 3
+	config.WatchdogAction = watchdogRestart
+
+	return config
+}
+
+// stubReference is a reference explorer with a fixed block number
+type stubReference struct {
+	blockNumber int64
+}
+
+func (s *stubReference) BlockNumber() (*big.Int, error) {
+	return big.NewInt(s.blockNumber), nil
+}
+
+func (s *stubReference) BlockNumberContext(ctx context.Context) (*big.Int, error) {
+	return s.BlockNumber()
+}
+
+func TestGatherReference(t *testing.T) {
+	cases := []struct {
+		name      string
+		node      int64
+		reference int64
+		synced    bool
+	}{
+		{"ahead", 1020, 1000, true},
+		{"far ahead", 5000, 1000, true},
+		{"equal", 1000, 1000, true},
+		{"behind within threshold", 995, 1000, true},
+		{"behind", 994, 1000, false},
+		{"far behind", 10, 1000, false},
+	}
+
+	for _, c := range cases {
+		config := DefaultConfig()
+		config.SyncThreshold = 5
+
+		m := &Monitor{
+			config:    config,
+			explorers: []*referenceExplorer{{"stub", &stubReference{c.reference}}},
+		}
+
+		if err := m.gatherReference(big.NewInt(c.node)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if m.synced != c.synced {
+			t.Fatalf("%s: expected synced %v, found %v", c.name, c.synced, m.synced)
+		}
+		if *m.blocksBehind != c.reference-c.node {
+			t.Fatalf("%s: expected %d blocks behind, found %d", c.name, c.reference-c.node, *m.blocksBehind)
+		}
+	}
+}
+
+func TestSyncThreshold(t *testing.T) {
+	config := DefaultConfig()
+	config.SyncThresholdSeconds = 60
+
+	m := &Monitor{config: config}
+
+	// 12s blocks, observed every 3 blocks
+	for i := 0; i < 3; i++ {
+		m.tpsWindow = append(m.tpsWindow, tpsSample{100, 36 * time.Second, 3})
+	}
+
+	if threshold := m.syncThreshold(); threshold != 5 {
+		t.Fatalf("expected 5 blocks, found %d", threshold)
+	}
+}
+
+func TestTPS(t *testing.T) {
+	m := &Monitor{}
+
+	// 12s blocks of 120 transactions, observed every 3 blocks
+	for i := 0; i < 3; i++ {
+		m.tpsWindow = append(m.tpsWindow, tpsSample{120, 36 * time.Second, 3})
+	}
+
+	if tps := m.tps(); tps != 10 {
+		t.Fatalf("expected 10 tps, found %f", tps)
+	}
+}
+
+func TestWatchdogHungNode(t *testing.T) {
+	node := newHungNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cycles time out before the watchdog trips
+	time.Sleep(10 * m.config.RPCInterval)
+
+	m.heartbeatLock.Lock()
+	elapsed := time.Since(m.heartbeat)
+	generation := m.loopGeneration
+	m.heartbeatLock.Unlock()
+
+	if elapsed >= m.watchdogTimeout() {
+		t.Fatalf("no heartbeat for %s", elapsed)
+	}
+	if generation != 1 {
+		t.Fatalf("expected a single loop, found %d", generation)
+	}
+
+	cancel()
+	if err := m.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchdogRestartLoop(t *testing.T) {
+	node := newHungNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !m.restartLoop(ctx) {
+			t.Fatal("monitor stopped")
+		}
+	}
+
+	m.heartbeatLock.Lock()
+	generation := m.loopGeneration
+	m.heartbeatLock.Unlock()
+
+	if generation != 4 {
+		t.Fatalf("expected 4 loops, found %d", generation)
+	}
+
+	// the replaced loops stopped, only the last one is left
+	cancel()
+	if err := m.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetupApisReuseTransport(t *testing.T) {
+	node := newStubNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+
+	var transports []http.RoundTripper
+	for i := 0; i < 3; i++ {
+		if err := m.setupApis(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		transports = append(transports, m.ethClient.client.Transport)
+
+		etherscan := m.explorers[0].source.(*Etherscan)
+		if etherscan.client.Transport != m.explorerTransport {
+			t.Fatal("expected the transport of the explorers on every reconnect")
+		}
+	}
+
+	for _, transport := range transports {
+		if transport != m.rpcTransport {
+			t.Fatal("expected the transport of the endpoint on every reconnect")
+		}
+	}
+}
+
+func TestClientLabels(t *testing.T) {
+	node := newStubNode()
+	defer node.Close()
+	peer := newStubNode()
+	defer peer.Close()
+
+	config := newTestConfig(node.URL)
+	config.PeerEndpoints = []string{peer.URL}
+
+	m, err := NewMonitor(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.setupApis(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := func(client *EthClient) string {
+		for _, label := range client.metricLabels() {
+			if label.Name == "endpoint" {
+				return label.Value
+			}
+		}
+		return ""
+	}
+
+	if found := endpoint(m.ethClient); found != node.URL {
+		t.Fatalf("expected the endpoint %s, found %s", node.URL, found)
+	}
+	if found := endpoint(m.peerClients[0]); found != peer.URL {
+		t.Fatalf("expected the endpoint %s, found %s", peer.URL, found)
+	}
+}
+
+func TestPropagationDelay(t *testing.T) {
+	node := newStubNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+	if err := m.setupApis(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a finalized block, long behind the latest head
+	finalized := time.Now().Add(-time.Hour)
+	block := &Block{Number: big.NewInt(900), Timestamp: &finalized}
+
+	delay, err := m.propagationDelay(block, big.NewInt(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay >= time.Minute {
+		t.Fatalf("expected the delay of the latest head, found %s", delay)
+	}
+
+	delay, err = m.propagationDelay(block, big.NewInt(900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay < time.Hour {
+		t.Fatalf("expected the delay of the block, found %s", delay)
+	}
+}
+
+func TestWatchdogMinIntervals(t *testing.T) {
+	config := DefaultConfig()
+	config.Endpoint = "http://localhost:8545"
+	config.LogOutput = ioutil.Discard
+	config.WatchdogIntervals = 1
+
+	if _, err := NewMonitor(config); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// TestConcurrentHandlers runs the http handlers along the gather loop.
+// Run with -race.
+func TestConcurrentHandlers(t *testing.T) {
+	node := newStubNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(handler func(http.ResponseWriter, *http.Request) (interface{}, error)) {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest("GET", "/", nil))
+	}
+
+	deadline := time.Now().Add(20 * m.config.RPCInterval)
+	for time.Now().Before(deadline) {
+		if score := m.currentScore(); score == nil {
+			t.Fatal("no score published")
+		}
+		get(m.http.LivezRequest)
+		m.selfTest(context.Background())
+		m.http.observeScrape()
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := m.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,193 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	metricGauge   = "gauge"
+	metricCounter = "counter"
+	metricSummary = "summary"
+)
+
+// MetricDesc describes a metric exported by the monitor
+type MetricDesc struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Labels []string `json:"labels"`
+}
+
+// metricDescs lists the metrics exported by the monitor. It must be
+// updated whenever a metric is added.
+var metricDescs = []MetricDesc{
+	{"connected", metricGauge, []string{"node"}},
+	{"connection.downtimeSeconds", metricCounter, []string{"node"}},
+	{"reconnect.duration", metricSummary, []string{"node"}},
+	{"startup.warmingUp", metricGauge, []string{"node"}},
+	{"process.uptimeSeconds", metricGauge, []string{"node"}},
+	{"process.startTime", metricGauge, []string{"node"}},
+	{"gather.watchdogTripped", metricCounter, []string{"node"}},
+	{"scrape.observedInterval", metricGauge, []string{"node"}},
+	{"rpc.circuitOpen", metricGauge, []string{"node"}},
+	{"consul.registered", metricGauge, []string{"node", "datacenter"}},
+	{"consul.registrationAttempts", metricCounter, []string{"node", "datacenter"}},
+	{"rpc.responseBytes", metricSummary, []string{"node", "endpoint", "method"}},
+	{"rpc.idMismatches", metricCounter, []string{"node", "endpoint", "method"}},
+	{"rpc.responseTooLarge", metricCounter, []string{"node", "endpoint", "method"}},
+	{"rpc.dnsFailures", metricCounter, []string{"node", "endpoint_host"}},
+	{"rpc.callsPerCycle", metricGauge, []string{"node", "method"}},
+	{"rpc.batchSize", metricGauge, []string{"node"}},
+	{"rpc.batchLatency", metricSummary, []string{"node"}},
+	{"peers", metricGauge, []string{"node"}},
+	{"peers.churn", metricGauge, []string{"node"}},
+	{"peers.distinctSeen", metricGauge, []string{"node"}},
+	{"peers.inbound", metricGauge, []string{"node"}},
+	{"peers.outbound", metricGauge, []string{"node"}},
+	{"peers.utilization", metricGauge, []string{"node"}},
+	{"node.listening", metricGauge, []string{"node"}},
+	{"node.info", metricGauge, []string{"node", "enode", "listener_port", "discovery_port"}},
+	{"node.archive", metricGauge, []string{"node"}},
+	{"node.dbSizeBytes", metricGauge, []string{"node"}},
+	{"capabilities", metricGauge, []string{"node", "method"}},
+	{"chain.idMismatch", metricGauge, []string{"node"}},
+	{"blockNumber", metricGauge, []string{"node"}},
+	{"blockNumber.byTag", metricGauge, []string{"node", "tag"}},
+	{"syncing", metricGauge, []string{"node"}},
+	{"sync.etaSeconds", metricGauge, []string{"node"}},
+	{"sync.stalled", metricGauge, []string{"node"}},
+	{"sync.transitions", metricCounter, []string{"node"}},
+	{"sync.queuedBlocks", metricGauge, []string{"node"}},
+	{"sync.minBlockNumber", metricGauge, []string{"node"}},
+	{"blocktime", metricGauge, []string{"node"}},
+	{"block.age", metricGauge, []string{"node"}},
+	{"chain.tps", metricGauge, []string{"node"}},
+	{"block.nextExpectedIn", metricGauge, []string{"node"}},
+	{"block.propagationDelay", metricGauge, []string{"node"}},
+	{"clock.skewSeconds", metricGauge, []string{"node"}},
+	{"block.stalledCycles", metricGauge, []string{"node"}},
+	{"block.numbersSkipped", metricGauge, []string{"node"}},
+	{"block.gasUsedRatio", metricGauge, []string{"node"}},
+	{"block.avgFullness", metricGauge, []string{"node"}},
+	{"chain.gasThroughput", metricGauge, []string{"node"}},
+	{"chain.uncleRate", metricGauge, []string{"node"}},
+	{"block.minerCount", metricGauge, []string{"node", "miner"}},
+	{"reorg.depth", metricSummary, []string{"node"}},
+	{"block.reorgedOut", metricGauge, []string{"node"}},
+	{"block.pendingGap", metricGauge, []string{"node"}},
+	{"block.sample.avgGasPrice", metricGauge, []string{"node"}},
+	{"block.sample.valueTransferred", metricGauge, []string{"node"}},
+	{"block.sample.contractCreations", metricGauge, []string{"node"}},
+	{"reference.active", metricGauge, []string{"node", "explorer"}},
+	{"blocksbehind", metricGauge, []string{"node"}},
+	{"node.behindReference", metricGauge, []string{"node"}},
+	{"node.aheadOfReference", metricGauge, []string{"node"}},
+	{"gasPrice", metricSummary, []string{"node"}},
+	{"gasPrice.last", metricGauge, []string{"node"}},
+	{"gas.overCap", metricGauge, []string{"node", "cap"}},
+	{"gas.priceTrend", metricGauge, []string{"node"}},
+	{"gas.priceTrendEma", metricGauge, []string{"node"}},
+	{"gas.suggested.p25", metricGauge, []string{"node"}},
+	{"gas.suggested.p50", metricGauge, []string{"node"}},
+	{"gas.suggested.p75", metricGauge, []string{"node"}},
+	{"consensus.headSlot", metricGauge, []string{"node"}},
+	{"consensus.executionGap", metricGauge, []string{"node"}},
+	{"endpoints.blockNumber", metricGauge, []string{"node", "endpoint"}},
+	{"endpoints.maxHeightSpread", metricGauge, []string{"node"}},
+	{"txpool.oldestPendingAge", metricGauge, []string{"node"}},
+	{"txpool.pending", metricGauge, []string{"node"}},
+	{"txpool.queued", metricGauge, []string{"node"}},
+	{"txpool.maxPending", metricGauge, []string{"node"}},
+	{"txpool.utilization", metricGauge, []string{"node"}},
+	{"account.pendingTxCount", metricGauge, []string{"node", "address"}},
+	{"contract.eventCount", metricCounter, []string{"node", "address", "event"}},
+	{"contract.callErrors", metricCounter, []string{"node", "address", "metric"}},
+	{"canary.inclusionSeconds", metricGauge, []string{"node"}},
+	{"canary.success", metricGauge, []string{"node"}},
+
+	// Runtime of the exporter itself, emitted by go-metrics
+	{"runtime.num_goroutines", metricGauge, []string{}},
+	{"runtime.alloc_bytes", metricGauge, []string{}},
+	{"runtime.sys_bytes", metricGauge, []string{}},
+	{"runtime.malloc_count", metricGauge, []string{}},
+	{"runtime.free_count", metricGauge, []string{}},
+	{"runtime.heap_objects", metricGauge, []string{}},
+	{"runtime.total_gc_pause_ns", metricGauge, []string{}},
+	{"runtime.total_gc_runs", metricGauge, []string{}},
+	{"runtime.gc_pause_ns", metricSummary, []string{}},
+}
+
+// flatMetricName is the name of the metric in the prometheus sink, where
+// the dots become underscores
+func flatMetricName(name string) string {
+	return strings.Replace(name, ".", "_", -1)
+}
+
+// validateWatchMetrics checks the metric names of the watched storage
+// slots and contract calls are valid prometheus names that do not clash
+// with another metric. A clash makes the prometheus sink panic on registration.
+func validateWatchMetrics(config *Config) error {
+	seen := map[string]string{}
+	for _, desc := range metricDescs {
+		seen[flatMetricName(desc.Name)] = "a built-in metric"
+	}
+
+	check := func(name, kind string) error {
+		if !validMetricName.MatchString(name) {
+			return fmt.Errorf("Metric name '%s' of the %s is not a valid metric name", name, kind)
+		}
+		if other, ok := seen[flatMetricName(name)]; ok {
+			return fmt.Errorf("Metric name '%s' of the %s is already used by %s", name, kind, other)
+		}
+		seen[flatMetricName(name)] = "the " + kind
+		return nil
+	}
+
+	for _, target := range config.WatchStorage {
+		if err := check(target.Metric, "storage target "+target.Address); err != nil {
+			return err
+		}
+	}
+	for _, call := range config.WatchCalls {
+		if err := check(call.Metric, "contract call "+call.Address); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MetricNames returns the metrics exported with the given config,
+// including the configured storage targets and contract calls.
+func MetricNames(config *Config) []MetricDesc {
+	descs := []MetricDesc{}
+	descs = append(descs, metricDescs...)
+
+	for _, target := range config.WatchStorage {
+		descs = append(descs, MetricDesc{target.Metric, metricGauge, []string{"node", "address", "slot"}})
+	}
+	for _, call := range config.WatchCalls {
+		descs = append(descs, MetricDesc{call.Metric, metricGauge, []string{"node", "address"}})
+	}
+
+	// the client label goes along the node label
+	for indx := range descs {
+		labels := []string{}
+		for _, label := range descs[indx].Labels {
+			labels = append(labels, label)
+			if label == "node" {
+				labels = append(labels, "client")
+			}
+		}
+		descs[indx].Labels = labels
+	}
+
+	if config.EnableHostnameLabel == nil || *config.EnableHostnameLabel {
+		for indx := range descs {
+			labels := append([]string{}, descs[indx].Labels...)
+			descs[indx].Labels = append(labels, "host")
+		}
+	}
+
+	return descs
+}
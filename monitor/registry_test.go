@@ -0,0 +1,158 @@
+package monitor
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+func TestValidateWatchMetrics(t *testing.T) {
+	cases := []struct {
+		name    string
+		storage []string
+		calls   []string
+		err     bool
+	}{
+		{"valid", []string{"oracle_price"}, nil, false},
+		{"several", []string{"oracle_price", "oracle_round"}, []string{"token_supply"}, false},
+		{"invalid name", []string{"oracle/price"}, nil, true},
+		{"dotted name", []string{"oracle.price"}, nil, true},
+		{"built-in", []string{"peers"}, nil, true},
+		{"flattened built-in", []string{"block_age"}, nil, true},
+		{"duplicated", []string{"oracle_price", "oracle_price"}, nil, true},
+		{"invalid call", nil, []string{"token-supply"}, true},
+		{"built-in call", nil, []string{"blockNumber"}, true},
+		{"duplicated call", nil, []string{"token_supply", "token_supply"}, true},
+		{"call and storage", []string{"oracle_price"}, []string{"oracle_price"}, true},
+	}
+
+	for _, c := range cases {
+		config := DefaultConfig()
+		for _, metric := range c.storage {
+			config.WatchStorage = append(config.WatchStorage, StorageTarget{Address: "0x1", Slot: "0x0", Metric: metric})
+		}
+		for _, metric := range c.calls {
+			config.WatchCalls = append(config.WatchCalls, ContractCall{Address: "0x1", CallData: "0x18160ddd", ResultDecoder: decodeUint, Metric: metric})
+		}
+
+		err := validateWatchMetrics(config)
+		if c.err && err == nil {
+			t.Fatalf("%s: expected an error", c.name)
+		}
+		if !c.err && err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+	}
+}
+
+// recordingSink records the names and the label names of the metrics
+type recordingSink struct {
+	service string
+
+	lock    sync.Mutex
+	emitted map[string][]string
+}
+
+func (r *recordingSink) record(key []string, labels []metrics.Label) {
+	if len(key) > 0 && key[0] == r.service {
+		key = key[1:]
+	}
+
+	names := []string{}
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	sort.Strings(names)
+
+	r.lock.Lock()
+	r.emitted[strings.Join(key, ".")] = names
+	r.lock.Unlock()
+}
+
+// labels returns the label names of an emitted metric
+func (r *recordingSink) labels(name string) ([]string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	labels, ok := r.emitted[name]
+	return labels, ok
+}
+
+func (r *recordingSink) SetGauge(key []string, val float32) { r.record(key, nil) }
+func (r *recordingSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	r.record(key, labels)
+}
+func (r *recordingSink) EmitKey(key []string, val float32)     { r.record(key, nil) }
+func (r *recordingSink) IncrCounter(key []string, val float32) { r.record(key, nil) }
+func (r *recordingSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	r.record(key, labels)
+}
+func (r *recordingSink) AddSample(key []string, val float32) { r.record(key, nil) }
+func (r *recordingSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	r.record(key, labels)
+}
+
+// TestMetricNamesEmitted runs the monitor against a node and checks the
+// emitted metrics are listed with their labels
+func TestMetricNamesEmitted(t *testing.T) {
+	node := newStubNode()
+	defer node.Close()
+
+	m := newTestMonitor(t, node.URL)
+	disabled := false
+	m.config.EnableHostnameLabel = &disabled
+
+	conf := metrics.DefaultConfig("parity-pool")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	conf.EnableRuntimeMetrics = true
+	conf.ProfileInterval = 10 * time.Millisecond
+
+	sink := &recordingSink{service: conf.ServiceName, emitted: map[string][]string{}}
+	metrics.NewGlobal(conf, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * m.config.RPCInterval)
+
+	cancel()
+	if err := m.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := map[string][]string{}
+	for _, desc := range MetricNames(m.config) {
+		labels := append([]string{}, desc.Labels...)
+		sort.Strings(labels)
+		listed[desc.Name] = labels
+	}
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	for name, labels := range sink.emitted {
+		expected, ok := listed[name]
+		if !ok {
+			t.Errorf("metric %s is emitted but not listed", name)
+			continue
+		}
+		if strings.Join(labels, ",") != strings.Join(expected, ",") {
+			t.Errorf("metric %s is emitted with the labels %v, listed with %v", name, labels, expected)
+		}
+	}
+
+	// emitted on every profile interval
+	for name := range listed {
+		if _, ok := sink.emitted[name]; strings.HasPrefix(name, "runtime.") && !ok {
+			t.Errorf("metric %s is listed but not emitted", name)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// RemoteWriter pushes the metrics of the prometheus default registry to
+// a remote write endpoint
+type RemoteWriter struct {
+	addr   string
+	client *http.Client
+
+	// Drops the gauges whose value did not change. Nil sends every value
+	onChange *changeFilter
+}
+
+func NewRemoteWriter(addr string) *RemoteWriter {
+	return &RemoteWriter{
+		addr:   addr,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type remoteLabel struct {
+	name, value string
+}
+
+type remoteSeries struct {
+	labels []remoteLabel
+	value  float64
+}
+
+// key identifies the series by its sorted labels
+func (r remoteSeries) key() string {
+	var buf bytes.Buffer
+	for _, l := range r.labels {
+		fmt.Fprintf(&buf, "%s=%q,", l.name, l.value)
+	}
+	return buf.String()
+}
+
+// Push sends the current value of every metric
+func (r *RemoteWriter) Push() error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+
+	series := []remoteSeries{}
+	for _, family := range families {
+		familySeries := familySeries(family)
+
+		gauge := family.GetType() == dto.MetricType_GAUGE || family.GetType() == dto.MetricType_UNTYPED
+		if r.onChange != nil && gauge {
+			for _, s := range familySeries {
+				if r.onChange.changed(s.key(), s.value) {
+					series = append(series, s)
+				}
+			}
+			continue
+		}
+
+		series = append(series, familySeries...)
+	}
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	data := encodeWriteRequest(series, time.Now())
+
+	req, err := http.NewRequest("POST", r.addr, bytes.NewReader(snappyEncode(data)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// familySeries flattens a metric family the same way the text exposition
+// format does (i.e. summaries into quantiles, _sum and _count)
+func familySeries(family *dto.MetricFamily) []remoteSeries {
+	name := family.GetName()
+	series := []remoteSeries{}
+
+	for _, metric := range family.GetMetric() {
+		labels := []remoteLabel{}
+		for _, pair := range metric.GetLabel() {
+			labels = append(labels, remoteLabel{pair.GetName(), pair.GetValue()})
+		}
+
+		add := func(name string, value float64, extra ...remoteLabel) {
+			l := append([]remoteLabel{{"__name__", name}}, labels...)
+			l = append(l, extra...)
+			sort.Slice(l, func(i, j int) bool { return l[i].name < l[j].name })
+
+			series = append(series, remoteSeries{l, value})
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_GAUGE:
+			add(name, metric.GetGauge().GetValue())
+		case dto.MetricType_COUNTER:
+			add(name, metric.GetCounter().GetValue())
+		case dto.MetricType_UNTYPED:
+			add(name, metric.GetUntyped().GetValue())
+		case dto.MetricType_SUMMARY:
+			summary := metric.GetSummary()
+			for _, q := range summary.GetQuantile() {
+				add(name, q.GetValue(), remoteLabel{"quantile", formatFloat(q.GetQuantile())})
+			}
+			add(name+"_sum", summary.GetSampleSum())
+			add(name+"_count", float64(summary.GetSampleCount()))
+		case dto.MetricType_HISTOGRAM:
+			histogram := metric.GetHistogram()
+			for _, b := range histogram.GetBucket() {
+				add(name+"_bucket", float64(b.GetCumulativeCount()), remoteLabel{"le", formatFloat(b.GetUpperBound())})
+			}
+			add(name+"_bucket", float64(histogram.GetSampleCount()), remoteLabel{"le", "+Inf"})
+			add(name+"_sum", histogram.GetSampleSum())
+			add(name+"_count", float64(histogram.GetSampleCount()))
+		}
+	}
+
+	return series
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the series as a remote write WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteSeries, ts time.Time) []byte {
+	const (
+		wireVarint  = 0
+		wireFixed64 = 1
+		wireBytes   = 2
+	)
+
+	key := func(b *proto.Buffer, field, wire uint64) {
+		b.EncodeVarint(field<<3 | wire)
+	}
+
+	timestamp := ts.UnixNano() / int64(time.Millisecond)
+
+	req := proto.NewBuffer(nil)
+	for _, s := range series {
+		timeSeries := proto.NewBuffer(nil)
+
+		for _, l := range s.labels {
+			label := proto.NewBuffer(nil)
+			key(label, 1, wireBytes)
+			label.EncodeStringBytes(l.name)
+			key(label, 2, wireBytes)
+			label.EncodeStringBytes(l.value)
+
+			key(timeSeries, 1, wireBytes)
+			timeSeries.EncodeRawBytes(label.Bytes())
+		}
+
+		sample := proto.NewBuffer(nil)
+		key(sample, 1, wireFixed64)
+		sample.EncodeFixed64(math.Float64bits(s.value))
+		key(sample, 2, wireVarint)
+		sample.EncodeVarint(uint64(timestamp))
+
+		key(timeSeries, 2, wireBytes)
+		timeSeries.EncodeRawBytes(sample.Bytes())
+
+		key(req, 1, wireBytes)
+		req.EncodeRawBytes(timeSeries.Bytes())
+	}
+
+	return req.Bytes()
+}
+
+// snappyEncode encodes src in the snappy block format as literals only.
+// Remote write requires snappy framing but not actual compression.
+func snappyEncode(src []byte) []byte {
+	var buf bytes.Buffer
+
+	preamble := make([]byte, binary.MaxVarintLen64)
+	buf.Write(preamble[:binary.PutUvarint(preamble, uint64(len(src)))])
+
+	// literals are at most 1<<16 bytes so the length fits in two bytes
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+		src = src[len(chunk):]
+
+		n := len(chunk) - 1
+		switch {
+		case n < 60:
+			buf.WriteByte(byte(n) << 2)
+		case n < 1<<8:
+			buf.WriteByte(60 << 2)
+			buf.WriteByte(byte(n))
+		default:
+			buf.WriteByte(61 << 2)
+			buf.WriteByte(byte(n))
+			buf.WriteByte(byte(n >> 8))
+		}
+		buf.Write(chunk)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,132 @@
+package monitor
+
+import (
+	"math"
+	"time"
+)
+
+// scoreWindowSize is the number of cycles the error rate is computed over
+const scoreWindowSize = 20
+
+// ScoreWeights are the weights of every input of the health score
+type ScoreWeights struct {
+	Synced       float64 `json:"synced"`
+	BlocksBehind float64 `json:"blocks_behind"`
+	Peers        float64 `json:"peers"`
+	BlockAge     float64 `json:"block_age"`
+	ErrorRate    float64 `json:"error_rate"`
+}
+
+func DefaultScoreWeights() *ScoreWeights {
+	return &ScoreWeights{
+		Synced:       3,
+		BlocksBehind: 2,
+		Peers:        1,
+		BlockAge:     2,
+		ErrorRate:    1,
+	}
+}
+
+// Score is the health of the node from 0 to 100. Every input of the
+// breakdown goes from 0 (unhealthy) to 1 (healthy).
+type Score struct {
+	Score     float64            `json:"score"`
+	Breakdown map[string]float64 `json:"breakdown"`
+}
+
+// recordCycle adds the result of a gather cycle to the error rate window
+func (m *Monitor) recordCycle(failed bool) {
+	m.cycleFailures = append(m.cycleFailures, failed)
+	if len(m.cycleFailures) > scoreWindowSize {
+		m.cycleFailures = m.cycleFailures[1:]
+	}
+}
+
+// publishScore computes the score from the state of the gather loop.
+// Called from the loop only.
+func (m *Monitor) publishScore() {
+	score := m.score()
+
+	m.scoreLock.Lock()
+	defer m.scoreLock.Unlock()
+
+	m.lastScore = score
+}
+
+// currentScore returns the score published after the last gather cycle
+func (m *Monitor) currentScore() *Score {
+	m.scoreLock.Lock()
+	defer m.scoreLock.Unlock()
+
+	return m.lastScore
+}
+
+func (m *Monitor) score() *Score {
+	weights := m.config.ScoreWeights
+	if weights == nil {
+		weights = DefaultScoreWeights()
+	}
+
+	breakdown := map[string]float64{}
+
+	synced := 0.0
+	if m.connected && m.synced {
+		synced = 1
+	}
+	breakdown["synced"] = synced
+
+	// linear down to zero at ten times the sync threshold
+	behind := 0.0
+	if m.blocksBehind != nil {
+		limit := math.Max(float64(m.syncThreshold()), 1) * 10
+		behind = 1 - math.Min(math.Max(float64(*m.blocksBehind), 0)/limit, 1)
+	}
+	breakdown["blocks_behind"] = behind
+
+	peers := 1.0
+	target := m.config.WarnPeers
+	if target < m.config.MinPeers {
+		target = m.config.MinPeers
+	}
+	if target > 0 {
+		peers = math.Min(float64(m.peers)/float64(target), 1)
+	}
+	breakdown["peers"] = peers
+
+	// linear down to zero at ten block times
+	age := 0.0
+	if m.lastBlock != nil {
+		blockTime := m.expectedBlockTime
+		if blockTime == 0 {
+			blockTime = 15 * time.Second
+		}
+		elapsed := time.Since(*m.lastBlock.Timestamp)
+		age = 1 - math.Min(math.Max(elapsed.Seconds(), 0)/(blockTime.Seconds()*10), 1)
+	}
+	breakdown["block_age"] = age
+
+	errorRate := 0.0
+	if len(m.cycleFailures) != 0 {
+		failed := 0
+		for _, f := range m.cycleFailures {
+			if f {
+				failed++
+			}
+		}
+		errorRate = 1 - float64(failed)/float64(len(m.cycleFailures))
+	}
+	breakdown["error_rate"] = errorRate
+
+	total := weights.Synced + weights.BlocksBehind + weights.Peers + weights.BlockAge + weights.ErrorRate
+	if total <= 0 {
+		return &Score{0, breakdown}
+	}
+
+	weighted := weights.Synced*synced +
+		weights.BlocksBehind*behind +
+		weights.Peers*peers +
+		weights.BlockAge*age +
+		weights.ErrorRate*errorRate
+
+	return &Score{math.Round(100 * weighted / total), breakdown}
+}
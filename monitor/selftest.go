@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// SelfTestCheck is the result of a single check of the self test
+type SelfTestCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SelfTestReport is the result of probing every dependency of the
+// exporter on demand
+type SelfTestReport struct {
+	Passed bool            `json:"passed"`
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+func (r *SelfTestReport) add(name string, err error) {
+	check := SelfTestCheck{Name: name, Passed: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+		r.Passed = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// selfTestTimeout bounds every probe of the reference explorers, which
+// are usually slower than the node
+const selfTestTimeout = 10 * time.Second
+
+// selfTest probes the node, the reference explorers and consul, the same
+// dependencies the gather loop and the registration use
+func (m *Monitor) selfTest(ctx context.Context) *SelfTestReport {
+	report := &SelfTestReport{Passed: true}
+
+	// replaced by the gather loop on reconnect
+	m.stateLock.RLock()
+	client, explorers := m.ethClient, m.explorers
+	m.stateLock.RUnlock()
+
+	if client == nil {
+		report.add("rpc", fmt.Errorf("not connected yet"))
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, m.config.LivenessTimeout)
+		_, err := client.BlockNumberContext(ctx)
+		cancel()
+		report.add("rpc", err)
+	}
+
+	for _, explorer := range explorers {
+		ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		_, err := explorer.source.BlockNumberContext(ctx)
+		cancel()
+		report.add("reference:"+explorer.name, err)
+	}
+
+	if m.config.ConsulConfig.IsEnabled() {
+		report.add("consul", m.checkConsul())
+	}
+
+	return report
+}
+
+// checkConsul checks the consul agent answers
+func (m *Monitor) checkConsul() error {
+	consulConfig := consulapi.DefaultConfig()
+	consulConfig.Address = m.config.ConsulConfig.Address
+
+	client, err := consulapi.NewClient(consulConfig)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Agent().NodeName()
+	return err
+}
@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Webhook posts the state changes of the node. It is best effort, a
+// failed post is only logged.
+type Webhook struct {
+	addr   string
+	client *http.Client
+	logger *log.Logger
+}
+
+// webhookEvent is the payload posted on every state change
+type webhookEvent struct {
+	Node      string `json:"node"`
+	Chain     string `json:"chain"`
+	Event     string `json:"event"`
+	Synced    bool   `json:"synced"`
+	Connected bool   `json:"connected"`
+	Block     int64  `json:"block"`
+	Timestamp int64  `json:"ts"`
+}
+
+func NewWebhook(addr string, logger *log.Logger) *Webhook {
+	return &Webhook{
+		addr:   addr,
+		client: &http.Client{Timeout: 5 * time.Second},
+		logger: logger,
+	}
+}
+
+// Post sends the event in the background so a slow webhook does not
+// block the gather loop
+func (w *Webhook) Post(event *webhookEvent) {
+	go func() {
+		if err := w.post(event); err != nil {
+			w.logger.Printf("[WARN] Failed to post the %s event to the webhook: %v", event.Event, err)
+		}
+	}()
+}
+
+func (w *Webhook) post(event *webhookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.addr, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// webhooks often answer 204
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}